@@ -1,18 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"log"
 	"math"
 )
 
 func main() {
 	testDevice := device.New(6)
 	program := device.Parse("day19/input.txt")
-	testDevice.Execute(program, math.MaxInt32)
-	fmt.Printf("Part 1: After execution, registers = %v", testDevice.Registers)
+	if _, err := testDevice.Execute(context.Background(), program, math.MaxInt32); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Part 1: After execution, registers = %v\n", testDevice.Registers)
 
-	fmt.Println(program.ToGo())
+	testDevice = device.New(6)
+	testDevice.Registers[0] = 1
+	if _, err := testDevice.Execute(context.Background(), program, math.MaxInt); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Part 2: After execution, registers = %v\n", testDevice.Registers)
+
+	source, err := program.ToGo("elfcode", 6)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(source)
 }
 
 
@@ -0,0 +1,150 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Breakpoint stops execution before the instruction at IP runs, provided
+// its condition (if any) holds. See condition.go for the condition syntax.
+type Breakpoint struct {
+	ID        int
+	IP        int
+	Condition string
+	Hits      int
+	cond      condition
+	previous  []int
+}
+
+// Watchpoint stops execution after an instruction writes Register.
+type Watchpoint struct {
+	ID       int
+	Register int
+	Hits     int
+}
+
+// SetBreakpoint adds a breakpoint at instruction ip guarded by condition,
+// which may be empty.
+func (d *Device) SetBreakpoint(ip int, condition string) (*Breakpoint, error) {
+	breakpoint := &Breakpoint{IP: ip, Condition: condition}
+	if condition != "" {
+		cond, err := parseCondition(condition, len(d.Registers))
+		if err != nil {
+			return nil, err
+		}
+		breakpoint.cond = cond
+	}
+	d.nextID++
+	breakpoint.ID = d.nextID
+	if d.breakpoints == nil {
+		d.breakpoints = make(map[int][]*Breakpoint)
+	}
+	d.breakpoints[ip] = append(d.breakpoints[ip], breakpoint)
+	return breakpoint, nil
+}
+
+// ClearBreakpoint removes the breakpoint with the given ID, reporting
+// whether there was one.
+func (d *Device) ClearBreakpoint(id int) bool {
+	for ip, breakpoints := range d.breakpoints {
+		for i, breakpoint := range breakpoints {
+			if breakpoint.ID == id {
+				d.breakpoints[ip] = append(breakpoints[:i:i], breakpoints[i+1:]...)
+				if len(d.breakpoints[ip]) == 0 {
+					delete(d.breakpoints, ip)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the device's breakpoints in the order they were set.
+func (d *Device) Breakpoints() []*Breakpoint {
+	var breakpoints []*Breakpoint
+	for _, atIP := range d.breakpoints {
+		breakpoints = append(breakpoints, atIP...)
+	}
+	sort.Slice(breakpoints, func(i, j int) bool {
+		return breakpoints[i].ID < breakpoints[j].ID
+	})
+	return breakpoints
+}
+
+// Watch adds a watchpoint on register.
+func (d *Device) Watch(register int) (*Watchpoint, error) {
+	if register < 0 || register >= len(d.Registers) {
+		return nil, fmt.Errorf("%w: r%d on a device with %d registers", ErrRegisterOutOfRange, register, len(d.Registers))
+	}
+	d.nextID++
+	watchpoint := &Watchpoint{ID: d.nextID, Register: register}
+	if d.watchpoints == nil {
+		d.watchpoints = make(map[int][]*Watchpoint)
+	}
+	d.watchpoints[register] = append(d.watchpoints[register], watchpoint)
+	return watchpoint, nil
+}
+
+// ClearWatchpoint removes the watchpoint with the given ID, reporting
+// whether there was one.
+func (d *Device) ClearWatchpoint(id int) bool {
+	for register, watchpoints := range d.watchpoints {
+		for i, watchpoint := range watchpoints {
+			if watchpoint.ID == id {
+				d.watchpoints[register] = append(watchpoints[:i:i], watchpoints[i+1:]...)
+				if len(d.watchpoints[register]) == 0 {
+					delete(d.watchpoints, register)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Watchpoints returns the device's watchpoints in the order they were set.
+func (d *Device) Watchpoints() []*Watchpoint {
+	var watchpoints []*Watchpoint
+	for _, onRegister := range d.watchpoints {
+		watchpoints = append(watchpoints, onRegister...)
+	}
+	sort.Slice(watchpoints, func(i, j int) bool {
+		return watchpoints[i].ID < watchpoints[j].ID
+	})
+	return watchpoints
+}
+
+// breakpointAt returns the first breakpoint at the current instruction whose
+// condition holds. Every condition there is evaluated so that "changed"
+// tracks each visit.
+func (d *Device) breakpointAt() *Breakpoint {
+	var hit *Breakpoint
+	for _, breakpoint := range d.breakpoints[d.IP] {
+		if breakpoint.cond != nil {
+			env := conditionEnv{d.IP, d.Registers, breakpoint.previous}
+			holds := breakpoint.cond(&env)
+			if breakpoint.previous == nil {
+				breakpoint.previous = make([]int, len(d.Registers))
+			}
+			copy(breakpoint.previous, d.Registers)
+			if !holds {
+				continue
+			}
+		}
+		if hit == nil {
+			hit = breakpoint
+			hit.Hits++
+		}
+	}
+	return hit
+}
+
+// watchpointOn returns the first watchpoint on register, if any.
+func (d *Device) watchpointOn(register int) *Watchpoint {
+	if watchpoints := d.watchpoints[register]; len(watchpoints) > 0 {
+		watchpoints[0].Hits++
+		return watchpoints[0]
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package device
+
+import (
+	"testing"
+)
+
+func loadDay21(t *testing.T) *Device {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDevice := New(6)
+	if err := testDevice.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	return testDevice
+}
+
+func TestBreakpoint(t *testing.T) {
+	testDevice := loadDay21(t)
+	breakpoint, err := testDevice.SetBreakpoint(28, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []int
+	for i := 0; i < 3; i++ {
+		result := testDevice.Run(1000000)
+		if result.Reason != BreakpointHit || result.Breakpoint != breakpoint || result.IP != 28 {
+			t.Fatalf("Run %d stopped with %v at %d", i, result.Reason, result.IP)
+		}
+		seen = append(seen, testDevice.Registers[4])
+	}
+	if seen[0] != 15823996 || seen[1] == seen[0] || breakpoint.Hits != 3 {
+		t.Errorf("r4 at the breakpoint = %v after %d hits", seen, breakpoint.Hits)
+	}
+
+	if !testDevice.ClearBreakpoint(breakpoint.ID) || testDevice.ClearBreakpoint(breakpoint.ID) {
+		t.Errorf("ClearBreakpoint(%d) did not remove exactly one breakpoint", breakpoint.ID)
+	}
+	if result := testDevice.Run(1000); result.Reason != BudgetExhausted {
+		t.Errorf("Run after clearing the breakpoint stopped with %v", result.Reason)
+	}
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	testDevice := loadDay21(t)
+	// r3 is divided down to zero in the inner loop, so it changes between
+	// most visits to instruction 8 but not all.
+	breakpoint, err := testDevice.SetBreakpoint(8, "ip==8 && r3 changed && r3 < 256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testDevice.Run(1000000)
+	if result.Reason != BreakpointHit || result.Breakpoint != breakpoint {
+		t.Fatalf("Run stopped with %v", result.Reason)
+	}
+	if r3 := testDevice.Registers[3]; r3 >= 256 {
+		t.Errorf("Breakpoint fired with r3 = %d", r3)
+	}
+}
+
+func TestBreakpointOnIPRegister(t *testing.T) {
+	testDevice := loadDay21(t)
+	// Day 21 binds the IP to r1, which must hold 28 when instruction 28 is
+	// about to run.
+	breakpoint, err := testDevice.SetBreakpoint(28, "r1==28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testDevice.Run(1000000)
+	if result.Reason != BreakpointHit || result.Breakpoint != breakpoint || result.Registers[1] != 28 {
+		t.Errorf("Run stopped with %v at %d with registers %v", result.Reason, result.IP, result.Registers)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	var tests = []struct {
+		condition string
+		ip        int
+		registers []int
+		expected  bool
+	}{
+		{"ip==28", 28, []int{0, 0}, true},
+		{"ip != 28", 28, []int{0, 0}, false},
+		{"r1 > 2 || r0 == 1", 0, []int{1, 0}, true},
+		{"r1 >= 2 && r0 <= 1", 0, []int{1, 2}, true},
+		{"!(r1 < 2)", 0, []int{1, 2}, true},
+		{"r1", 0, []int{0, 0}, false},
+		{"r1 == -3", 0, []int{0, -3}, true},
+	}
+	for _, test := range tests {
+		cond, err := parseCondition(test.condition, len(test.registers))
+		if err != nil {
+			t.Errorf("parseCondition(%q) returned error %v", test.condition, err)
+			continue
+		}
+		if result := cond(&conditionEnv{test.ip, test.registers, nil}); result != test.expected {
+			t.Errorf("%q with ip=%d %v = %v", test.condition, test.ip, test.registers, result)
+		}
+	}
+
+	for _, bad := range []string{"r6 == 1", "ip ==", "(r1 == 2", "r1 == 2)", "ip changed", "r1 $ 2"} {
+		if _, err := parseCondition(bad, 6); err == nil {
+			t.Errorf("parseCondition(%q) did not return an error", bad)
+		}
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	testDevice := loadDay21(t)
+	watchpoint, err := testDevice.Watch(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testDevice.Run(1000000)
+	if result.Reason != WatchpointHit || result.Watchpoint != watchpoint {
+		t.Fatalf("Run stopped with %v", result.Reason)
+	}
+	// bori 4 65536 3 at instruction 6 is the first write to r3.
+	if result.IP != 7 || testDevice.Registers[3] != 65536 {
+		t.Errorf("Watchpoint fired at ip %d with r3 = %d", result.IP, testDevice.Registers[3])
+	}
+	if _, err := testDevice.Watch(6); err == nil {
+		t.Errorf("Watch(6) on a 6 register device did not return an error")
+	}
+	if !testDevice.ClearWatchpoint(watchpoint.ID) || len(testDevice.Watchpoints()) != 0 {
+		t.Errorf("ClearWatchpoint(%d) did not remove the watchpoint", watchpoint.ID)
+	}
+}
@@ -0,0 +1,376 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// JumpKind classifies how an instruction passes control on. Any write to
+// the register bound by #ip is a jump.
+type JumpKind int
+
+const (
+	// Fallthrough instructions do not write the IP register.
+	Fallthrough JumpKind = iota
+	// ConstantJump always continues at a single target.
+	ConstantJump
+	// ComputedJump continues at one of a known set of targets, as when a
+	// comparison result is added to the IP register.
+	ComputedJump
+	// UnresolvedJump writes the IP register with a value that cannot be
+	// bounded statically, so it may continue anywhere.
+	UnresolvedJump
+)
+
+func (k JumpKind) String() string {
+	switch k {
+	case Fallthrough:
+		return "fallthrough"
+	case ConstantJump:
+		return "constant"
+	case ComputedJump:
+		return "computed"
+	case UnresolvedJump:
+		return "unresolved"
+	}
+	return fmt.Sprintf("JumpKind(%d)", int(k))
+}
+
+// Jump describes where control may go after an instruction. Targets are
+// instruction indices; those outside the program halt it. Targets is nil
+// for an UnresolvedJump.
+type Jump struct {
+	Kind    JumpKind
+	Targets []int
+}
+
+// Block is a basic block: instructions Start to End inclusive that always
+// run in sequence.
+type Block struct {
+	ID         int
+	Start, End int
+	Succs      []*Block
+	Preds      []*Block
+	// Halts is set when control may leave the program from this block.
+	Halts bool
+	// Unresolved is set when the block ends in an UnresolvedJump.
+	Unresolved bool
+}
+
+// CFG is the control-flow graph of a program.
+type CFG struct {
+	Program *Program
+	Blocks  []*Block
+	// Jumps holds the jump analysis of each instruction.
+	Jumps   []Jump
+	blockOf []int
+}
+
+// maxValueSet bounds the number of values tracked for one register.
+const maxValueSet = 16
+
+// valueSet is the set of values a register may hold at some point in the
+// program. A nil set means the value is unknown.
+type valueSet []int
+
+func newValueSet(values map[int]bool) valueSet {
+	if len(values) > maxValueSet {
+		return nil
+	}
+	set := make(valueSet, 0, len(values))
+	for v := range values {
+		set = append(set, v)
+	}
+	sort.Ints(set)
+	return set
+}
+
+// isComparison reports whether operation is a built-in comparison, whose
+// result is always 0 or 1.
+func isComparison(operation string) bool {
+	opcode, ok := basicOpcodes[operation]
+	return ok && opcode.comparison()
+}
+
+// apply evaluates operation on operand values, with register operands
+// already replaced by the register contents.
+func apply(operation string, a, b int) int {
+	registers := []int{a, b, 0}
+	kinds := operandKinds(operation)
+	operands := [2]int{a, b}
+	for i, kind := range kinds {
+		if kind == RegisterOperand {
+			operands[i] = i
+		}
+	}
+	opcode, _ := LookupOpcode(operation)
+	opcode.Execute(registers, operands[0], operands[1], 2)
+	return registers[2]
+}
+
+// evaluate returns the possible results of instruction given the possible
+// register values.
+func evaluate(instruction Instruction, registers []valueSet) valueSet {
+	kinds := operandKinds(instruction.operation)
+	var inputs [2]valueSet
+	for i, kind := range kinds {
+		value := instruction.a
+		if i == 1 {
+			value = instruction.b
+		}
+		switch kind {
+		case RegisterOperand:
+			inputs[i] = registers[value]
+		case ImmediateOperand:
+			inputs[i] = valueSet{value}
+		default:
+			inputs[i] = valueSet{0}
+		}
+	}
+	if inputs[0] == nil || inputs[1] == nil {
+		if isComparison(instruction.operation) {
+			return valueSet{0, 1}
+		}
+		return nil
+	}
+	results := make(map[int]bool)
+	for _, a := range inputs[0] {
+		for _, b := range inputs[1] {
+			results[apply(instruction.operation, a, b)] = true
+			if len(results) > maxValueSet {
+				return nil
+			}
+		}
+	}
+	return newValueSet(results)
+}
+
+// analyzeJumps classifies every instruction's jump, tracking register
+// values through straight-line code. Tracking starts afresh at each
+// leader, since control may arrive there from elsewhere.
+func (p *Program) analyzeJumps(leaders []bool, numRegisters int) []Jump {
+	jumps := make([]Jump, len(p.Instructions))
+	registers := make([]valueSet, numRegisters)
+	for i, instruction := range p.Instructions {
+		if leaders[i] {
+			for r := range registers {
+				registers[r] = nil
+			}
+		}
+		registers[p.IP] = valueSet{i}
+		result := evaluate(instruction, registers)
+		if instruction.c != p.IP {
+			registers[instruction.c] = result
+			jumps[i] = Jump{Fallthrough, []int{i + 1}}
+			continue
+		}
+		switch {
+		case result == nil:
+			jumps[i] = Jump{UnresolvedJump, nil}
+		case len(result) == 1:
+			jumps[i] = Jump{ConstantJump, []int{result[0] + 1}}
+		default:
+			targets := make([]int, len(result))
+			for j, value := range result {
+				targets[j] = value + 1
+			}
+			jumps[i] = Jump{ComputedJump, targets}
+		}
+	}
+	return jumps
+}
+
+// CFG recovers the program's basic blocks and the edges between them. The
+// program must be valid.
+func (p *Program) CFG() *CFG {
+	numRegisters := p.registersUsed()
+	n := len(p.Instructions)
+	leaders := make([]bool, n+1)
+	leaders[0] = true
+	var jumps []Jump
+	for changed := true; changed; {
+		changed = false
+		jumps = p.analyzeJumps(leaders, numRegisters)
+		mark := func(i int) {
+			if i >= 0 && i < n && !leaders[i] {
+				leaders[i] = true
+				changed = true
+			}
+		}
+		for i, jump := range jumps {
+			if jump.Kind == Fallthrough {
+				continue
+			}
+			mark(i + 1)
+			for _, target := range jump.Targets {
+				mark(target)
+			}
+		}
+	}
+
+	g := &CFG{Program: p, Jumps: jumps, blockOf: make([]int, n)}
+	for start := 0; start < n; {
+		end := start
+		for end+1 < n && !leaders[end+1] {
+			end++
+		}
+		block := &Block{ID: len(g.Blocks), Start: start, End: end}
+		for i := start; i <= end; i++ {
+			g.blockOf[i] = block.ID
+		}
+		g.Blocks = append(g.Blocks, block)
+		start = end + 1
+	}
+	for _, block := range g.Blocks {
+		jump := jumps[block.End]
+		if jump.Kind == UnresolvedJump {
+			block.Unresolved = true
+			continue
+		}
+		for _, target := range jump.Targets {
+			if target < 0 || target >= n {
+				block.Halts = true
+				continue
+			}
+			succ := g.Blocks[g.blockOf[target]]
+			block.Succs = append(block.Succs, succ)
+			succ.Preds = append(succ.Preds, block)
+		}
+	}
+	return g
+}
+
+// registersUsed returns the size of the smallest register file the program
+// can run on.
+func (p *Program) registersUsed() int {
+	used := p.IP + 1
+	for _, instruction := range p.Instructions {
+		kinds := operandKinds(instruction.operation)
+		values := [3]int{instruction.a, instruction.b, instruction.c}
+		for i, kind := range [3]OperandKind{kinds[0], kinds[1], RegisterOperand} {
+			if kind == RegisterOperand && values[i]+1 > used {
+				used = values[i] + 1
+			}
+		}
+	}
+	return used
+}
+
+// BlockOf returns the block containing instruction ip.
+func (g *CFG) BlockOf(ip int) *Block {
+	return g.Blocks[g.blockOf[ip]]
+}
+
+// DOT renders the graph in Graphviz format. Edges from computed jumps are
+// bold, and unresolved jumps point at a "?" node.
+func (g *CFG) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph program {\n")
+	sb.WriteString("\tnode [shape=box fontname=\"monospace\"];\n")
+	halts, unresolved := false, false
+	for _, block := range g.Blocks {
+		var label strings.Builder
+		for i := block.Start; i <= block.End; i++ {
+			label.WriteString(fmt.Sprintf("%d: %v\\l", i, g.Program.Instructions[i]))
+		}
+		sb.WriteString(fmt.Sprintf("\tb%d [label=\"%s\"];\n", block.ID, label.String()))
+	}
+	for _, block := range g.Blocks {
+		style := ""
+		if g.Jumps[block.End].Kind == ComputedJump {
+			style = " [style=bold]"
+		}
+		for _, succ := range block.Succs {
+			sb.WriteString(fmt.Sprintf("\tb%d -> b%d%s;\n", block.ID, succ.ID, style))
+		}
+		if block.Halts {
+			halts = true
+			sb.WriteString(fmt.Sprintf("\tb%d -> halt%s;\n", block.ID, style))
+		}
+		if block.Unresolved {
+			unresolved = true
+			sb.WriteString(fmt.Sprintf("\tb%d -> unresolved [style=dashed];\n", block.ID))
+		}
+	}
+	if halts {
+		sb.WriteString("\thalt [shape=doublecircle];\n")
+	}
+	if unresolved {
+		sb.WriteString("\tunresolved [shape=diamond label=\"?\"];\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// liveOut works out which registers may be read after each instruction
+// before being written again. Every register is live when the program
+// halts or after an unresolved jump. The IP register is left out, as it is
+// always written before it is read.
+func (g *CFG) liveOut() [][]bool {
+	return g.liveness(true)
+}
+
+// liveness is liveOut, with registers live when the program halts only if
+// liveAtHalt is set.
+func (g *CFG) liveness(liveAtHalt bool) [][]bool {
+	p := g.Program
+	n := len(p.Instructions)
+	numRegisters := p.registersUsed()
+	all := make([]bool, numRegisters)
+	for r := range all {
+		all[r] = liveAtHalt && r != p.IP
+	}
+	liveIn := make([][]bool, n)
+	liveOut := make([][]bool, n)
+	for i := range liveIn {
+		liveIn[i] = make([]bool, numRegisters)
+		liveOut[i] = make([]bool, numRegisters)
+	}
+	successors := func(i int) []int {
+		jump := g.Jumps[i]
+		if jump.Kind == UnresolvedJump {
+			targets := make([]int, n+1)
+			for t := range targets {
+				targets[t] = t
+			}
+			return targets
+		}
+		return jump.Targets
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			out := liveOut[i]
+			for _, target := range successors(i) {
+				in := all
+				if target >= 0 && target < n {
+					in = liveIn[target]
+				}
+				for r := range out {
+					if in[r] && !out[r] {
+						out[r] = true
+						changed = true
+					}
+				}
+			}
+			instruction := p.Instructions[i]
+			in := liveIn[i]
+			for r := range in {
+				live := out[r] && r != instruction.c
+				if !in[r] && live {
+					in[r] = true
+					changed = true
+				}
+			}
+			kinds := operandKinds(instruction.operation)
+			for j, value := range [2]int{instruction.a, instruction.b} {
+				if kinds[j] == RegisterOperand && value != p.IP && !in[value] {
+					in[value] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveOut
+}
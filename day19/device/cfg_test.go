@@ -0,0 +1,115 @@
+package device
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJumps(t *testing.T) {
+	var tests = []struct {
+		filename string
+		jumps    map[int]Jump
+	}{
+		{"../input.txt", map[int]Jump{
+			0:  {ConstantJump, []int{17}},
+			5:  {ComputedJump, []int{6, 7}},
+			6:  {ConstantJump, []int{8}},
+			10: {ComputedJump, []int{11, 12}},
+			11: {ConstantJump, []int{3}},
+			14: {ComputedJump, []int{15, 16}},
+			15: {ConstantJump, []int{2}},
+			16: {ConstantJump, []int{257}},
+			25: {UnresolvedJump, nil},
+			26: {ConstantJump, []int{1}},
+			35: {ConstantJump, []int{1}},
+		}},
+		{"../../day21/input.txt", map[int]Jump{
+			3:  {ComputedJump, []int{4, 5}},
+			4:  {ConstantJump, []int{1}},
+			14: {ComputedJump, []int{15, 16}},
+			15: {ConstantJump, []int{17}},
+			16: {ConstantJump, []int{28}},
+			21: {ComputedJump, []int{22, 23}},
+			22: {ConstantJump, []int{24}},
+			23: {ConstantJump, []int{26}},
+			25: {ConstantJump, []int{18}},
+			27: {ConstantJump, []int{8}},
+			29: {ComputedJump, []int{30, 31}},
+			30: {ConstantJump, []int{6}},
+		}},
+	}
+	for _, test := range tests {
+		program, err := ParseFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := program.CFG()
+		for i, jump := range g.Jumps {
+			expected, ok := test.jumps[i]
+			if !ok {
+				expected = Jump{Fallthrough, []int{i + 1}}
+			}
+			if !reflect.DeepEqual(jump, expected) {
+				t.Errorf("%s: jump at %d = %v, expected %v", test.filename, i, jump, expected)
+			}
+		}
+	}
+}
+
+func TestCFG(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := program.CFG()
+
+	var blocks [][2]int
+	for _, block := range g.Blocks {
+		blocks = append(blocks, [2]int{block.Start, block.End})
+	}
+	expected := [][2]int{
+		{0, 0}, {1, 3}, {4, 4}, {5, 5}, {6, 7}, {8, 14}, {15, 15}, {16, 16}, {17, 17}, {18, 21},
+		{22, 22}, {23, 23}, {24, 25}, {26, 27}, {28, 29}, {30, 30},
+	}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Blocks = %v, expected %v", blocks, expected)
+	}
+
+	halting := g.BlockOf(29)
+	if !halting.Halts || len(halting.Succs) != 1 || halting.Succs[0] != g.BlockOf(30) {
+		t.Errorf("Block of the halting comparison = %+v", halting)
+	}
+	loop := g.BlockOf(18)
+	if len(loop.Preds) != 2 || loop.Preds[0] != g.BlockOf(17) || loop.Preds[1] != g.BlockOf(24) {
+		t.Errorf("Predecessors of the division loop = %v", loop.Preds)
+	}
+
+	dot := g.DOT()
+	for _, expected := range []string{
+		"digraph program {",
+		"b14 [label=\"28: eqrr 4 0 5\\l29: addr 5 1 1\\l\"];",
+		"b14 -> b15 [style=bold];",
+		"b14 -> halt [style=bold];",
+		"b13 -> b5;",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("DOT output does not contain %q:\n%s", expected, dot)
+		}
+	}
+}
+
+func TestCFGUnresolved(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := program.CFG()
+	block := g.BlockOf(25)
+	if !block.Unresolved || len(block.Succs) != 0 || block.Start != 17 {
+		t.Errorf("Block of the r0 dependent jump = %+v", block)
+	}
+	if !strings.Contains(g.DOT(), " -> unresolved [style=dashed];") {
+		t.Errorf("DOT output does not show the unresolved jump:\n%s", g.DOT())
+	}
+}
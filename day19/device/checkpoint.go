@@ -0,0 +1,95 @@
+package device
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var ErrProgramMismatch = errors.New("checkpoint is for a different program")
+
+// Checkpoint is the state of a run, from which it can be resumed.
+type Checkpoint struct {
+	Program      string `json:"program"` // Program.Hash of the loaded program
+	IP           int    `json:"ip"`
+	Instructions int    `json:"instructions"`
+	Registers    []int  `json:"registers"`
+}
+
+// Hash returns a SHA-256 hash, in hex, of the #ip binding and instructions.
+// Comments, blank lines and the layout of the source do not affect it.
+func (p *Program) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "#ip %d\n", p.IP)
+	for _, instruction := range p.Instructions {
+		fmt.Fprintf(h, "%v\n", instruction)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Checkpoint returns the state of the device's run.
+func (d *Device) Checkpoint() (*Checkpoint, error) {
+	if d.program == nil {
+		return nil, ErrNoProgram
+	}
+	return &Checkpoint{d.program.Hash(), d.IP, d.Executed, append([]int(nil), d.Registers...)}, nil
+}
+
+// Restore puts the device back in the state of checkpoint c, which must
+// have been taken from a run of the loaded program.
+func (d *Device) Restore(c *Checkpoint) error {
+	switch {
+	case d.program == nil:
+		return ErrNoProgram
+	case c.Program != d.program.Hash():
+		return ErrProgramMismatch
+	case len(c.Registers) != len(d.Registers):
+		return fmt.Errorf("checkpoint has %d registers, not %d", len(c.Registers), len(d.Registers))
+	}
+	copy(d.Registers, c.Registers)
+	d.IP = c.IP
+	d.Executed = c.Instructions
+	d.stoppedAtBreakpoint = false
+	return nil
+}
+
+// WriteCheckpoint saves c to filename. It writes a temporary file and
+// renames it into place, so filename holds either the old checkpoint or the
+// new one even if the process dies part way through.
+func WriteCheckpoint(filename string, c *Checkpoint) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(c)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// ReadCheckpoint loads a checkpoint saved by WriteCheckpoint.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &c, nil
+}
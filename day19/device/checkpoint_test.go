@@ -0,0 +1,104 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(6)
+	d.Interpret = true
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	want := d.Continue()
+
+	filename := filepath.Join(t.TempDir(), "run.checkpoint")
+	d = New(6)
+	d.Interpret = true
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{1000, 12345} {
+		d.Run(n)
+		c, err := d.Checkpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteCheckpoint(filename, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Instructions != 13345 || c.Program != program.Hash() {
+		t.Errorf("got checkpoint %+v", c)
+	}
+	resumed := New(6)
+	resumed.Interpret = true
+	if err := resumed.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Restore(c); err != nil {
+		t.Fatal(err)
+	}
+	if got := resumed.Continue(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed run gave %+v, want %+v", got, want)
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("checkpoint directory holds %v, %v", entries, err)
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.ReadFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spaced := strings.NewReplacer(" ", "  ", "\n", "\n\n").Replace(string(source))
+	reformatted, err := ParseReader(strings.NewReader(spaced))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reformatted.Hash() != program.Hash() {
+		t.Error("hash depends on the layout of the source")
+	}
+	d := New(6)
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Load(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore(c); !errors.Is(err, ErrProgramMismatch) {
+		t.Errorf("Restore onto another program = %v", err)
+	}
+	if _, err := New(6).Checkpoint(); err != ErrNoProgram {
+		t.Errorf("Checkpoint with no program = %v", err)
+	}
+}
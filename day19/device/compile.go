@@ -0,0 +1,111 @@
+package device
+
+import (
+	"context"
+	"fmt"
+)
+
+// compiled is an instruction with its operation and operands bound, so that
+// running it needs no lookup by name.
+type compiled func(registers []int)
+
+// compile binds every instruction of a valid program.
+func (p *Program) compile() []compiled {
+	code := make([]compiled, len(p.Instructions))
+	for i, instruction := range p.Instructions {
+		code[i] = instruction.compile()
+	}
+	return code
+}
+
+// compile binds a registered instruction. The built-in opcodes get closures
+// of their own; others call Execute.
+func (i Instruction) compile() compiled {
+	a, b, c := i.a, i.b, i.c
+	if _, ok := basicOpcodes[i.operation]; !ok {
+		opcode, _ := LookupOpcode(i.operation)
+		return func(r []int) { opcode.Execute(r, a, b, c) }
+	}
+	switch i.operation {
+	case "addr":
+		return func(r []int) { r[c] = r[a] + r[b] }
+	case "addi":
+		return func(r []int) { r[c] = r[a] + b }
+	case "mulr":
+		return func(r []int) { r[c] = r[a] * r[b] }
+	case "muli":
+		return func(r []int) { r[c] = r[a] * b }
+	case "banr":
+		return func(r []int) { r[c] = r[a] & r[b] }
+	case "bani":
+		return func(r []int) { r[c] = r[a] & b }
+	case "borr":
+		return func(r []int) { r[c] = r[a] | r[b] }
+	case "bori":
+		return func(r []int) { r[c] = r[a] | b }
+	case "setr":
+		return func(r []int) { r[c] = r[a] }
+	case "seti":
+		return func(r []int) { r[c] = a }
+	case "gtir":
+		return func(r []int) { r[c] = boolToInt(a > r[b]) }
+	case "gtri":
+		return func(r []int) { r[c] = boolToInt(r[a] > b) }
+	case "gtrr":
+		return func(r []int) { r[c] = boolToInt(r[a] > r[b]) }
+	case "eqir":
+		return func(r []int) { r[c] = boolToInt(a == r[b]) }
+	case "eqri":
+		return func(r []int) { r[c] = boolToInt(r[a] == b) }
+	case "eqrr":
+		return func(r []int) { r[c] = boolToInt(r[a] == r[b]) }
+	}
+	panic(fmt.Errorf("unknown operation %v", i.operation))
+}
+
+// runCompiled is RunContext for a device with no tracer, breakpoints or
+// watchpoints, keeping the hot state in locals. Recognized idioms run
+// natively when they fit in the budget. cycles, if not nil, observes the
+// state between instructions.
+func (d *Device) runCompiled(ctx context.Context, n int, cycles *cycleDetector) Result {
+	code := d.code
+	idioms := d.idioms
+	registers := d.Registers
+	ipRegister := d.program.IP
+	ip := d.IP
+	executed := 0
+	stop := func(reason HaltReason, err error) Result {
+		d.IP = ip
+		d.Executed += executed
+		return d.result(reason, err)
+	}
+	for nextCheck := 0; ; executed++ {
+		if cycles != nil && executed > 0 && cycles.observe(ip, registers, executed) {
+			return d.cycleFound(ctx, cycles, executed, stop(CycleDetected, nil))
+		}
+		if ip < 0 || ip >= len(code) {
+			return stop(Halted, nil)
+		}
+		if executed >= n {
+			return stop(BudgetExhausted, nil)
+		}
+		if executed >= nextCheck {
+			if ctx.Err() != nil {
+				return stop(Cancelled, ctx.Err())
+			}
+			nextCheck = executed + cancelCheckInterval
+		}
+		if idioms != nil && idioms[ip] != nil {
+			match := idioms[ip]
+			if count, ok := match.run(registers, match.bindings, n-executed); ok {
+				registers[ipRegister] = match.exit - 1
+				ip = match.exit
+				executed += count - 1
+				continue
+			}
+		}
+		registers[ipRegister] = ip
+		code[ip](registers)
+		ip = registers[ipRegister] + 1
+	}
+}
@@ -0,0 +1,103 @@
+package device
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCompiledMatchesInterpreter(t *testing.T) {
+	var tests = []struct {
+		filename  string
+		registers []int
+		budget    int
+	}{
+		{"../input.txt", []int{0, 0, 0, 0, 0, 0}, 100000000},
+		{"../input.txt", []int{1, 0, 0, 0, 0, 0}, 1000000},
+		{"../../day21/input.txt", []int{15823996, 0, 0, 0, 0, 0}, 100000000},
+		{"../../day21/input.txt", []int{0, 0, 0, 0, 0, 0}, 1000000},
+	}
+	for _, test := range tests {
+		program, err := ParseFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results [2]Result
+		for i, interpret := range []bool{false, true} {
+			d := New(len(test.registers))
+			d.Interpret = interpret
+			copy(d.Registers, test.registers)
+			if results[i], err = d.Execute(context.Background(), program, test.budget); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("%s from %v: compiled %+v, interpreted %+v", test.filename, test.registers, results[0], results[1])
+		}
+	}
+}
+
+// benchmarkExecute runs day 21 for a million instructions from zeroed
+// registers. Without idioms the compiled closures run every instruction,
+// where with them most of the run is the native division loop.
+func benchmarkExecute(b *testing.B, interpret, idioms bool) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	d := New(6)
+	d.Interpret = interpret
+	for i := 0; i < b.N; i++ {
+		for r := range d.Registers {
+			d.Registers[r] = 0
+		}
+		if err := d.Load(program); err != nil {
+			b.Fatal(err)
+		}
+		if !idioms {
+			d.idioms = nil
+		}
+		if result := d.Run(1000000); result.Reason != BudgetExhausted {
+			b.Fatalf("stopped with %v", result.Reason)
+		}
+	}
+}
+
+func BenchmarkExecuteCompiled(b *testing.B) {
+	benchmarkExecute(b, false, true)
+}
+
+func BenchmarkExecuteCompiledWithoutIdioms(b *testing.B) {
+	benchmarkExecute(b, false, false)
+}
+
+func BenchmarkExecuteInterpreted(b *testing.B) {
+	benchmarkExecute(b, true, false)
+}
+
+// BenchmarkExecuteMapLookup runs the loop Execute had before compilation,
+// looking up each operation by name in a map without locking, as a
+// reference for the compiled forms.
+func BenchmarkExecuteMapLookup(b *testing.B) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	operations := make(map[string]Opcode)
+	for name, opcode := range basicOpcodes {
+		operations[name] = opcode
+	}
+	registers := make([]int, 6)
+	for i := 0; i < b.N; i++ {
+		for r := range registers {
+			registers[r] = 0
+		}
+		ip := 0
+		for executed := 0; executed < 1000000 && ip < len(program.Instructions); executed++ {
+			registers[program.IP] = ip
+			instruction := program.Instructions[ip]
+			operations[instruction.operation].Execute(registers, instruction.a, instruction.b, instruction.c)
+			ip = registers[program.IP] + 1
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A condition guards a breakpoint. The language is small:
+//
+//	ip==28 && r4 changed
+//	r0 > 10 || !(r1 == r2)
+//
+// Operands are ip, the registers r0, r1, ... and integers. Comparisons are
+// ==, !=, <, <=, > and >=, and they combine with &&, || and !. "rN changed"
+// is true when rN differs from its value the last time the condition was
+// evaluated, and always true on the first evaluation. A bare operand is
+// true when it is non-zero.
+type condition func(env *conditionEnv) bool
+
+type conditionEnv struct {
+	ip        int
+	registers []int
+	previous  []int // registers at the previous evaluation, nil on the first
+}
+
+type conditionValue func(env *conditionEnv) int
+
+type conditionParser struct {
+	tokens       []string
+	pos          int
+	numRegisters int
+}
+
+func parseCondition(text string, numRegisters int) (condition, error) {
+	tokens, err := tokenizeCondition(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens, numRegisters: numRegisters}
+	cond, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("condition %q: %v", text, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("condition %q: unexpected %q", text, p.tokens[p.pos])
+	}
+	return cond, nil
+}
+
+func tokenizeCondition(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		r := rune(text[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-':
+			start := i
+			i++
+			for i < len(text) && (unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		case strings.ContainsRune("()", r):
+			tokens = append(tokens, text[i:i+1])
+			i++
+		default:
+			matched := false
+			for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"} {
+				if strings.HasPrefix(text[i:], op) {
+					tokens = append(tokens, op)
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("condition %q: unexpected character %q", text, r)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *conditionParser) or() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *conditionEnv) bool {
+			// Evaluate both sides so that "changed" sees every evaluation.
+			a, b := l(env), right(env)
+			return a || b
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) and() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *conditionEnv) bool {
+			a, b := l(env), right(env)
+			return a && b
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) unary() (condition, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *conditionEnv) bool { return !operand(env) }, nil
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (condition, error) {
+	operandToken := p.peek()
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "changed":
+		p.next()
+		register, ok := p.register(operandToken)
+		if !ok {
+			return nil, fmt.Errorf("changed applies to a register, not %q", operandToken)
+		}
+		return func(env *conditionEnv) bool {
+			return env.previous == nil || env.previous[register] != env.registers[register]
+		}, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return compare(op, left, right), nil
+	}
+	return func(env *conditionEnv) bool { return left(env) != 0 }, nil
+}
+
+func compare(op string, left, right conditionValue) condition {
+	switch op {
+	case "==":
+		return func(env *conditionEnv) bool { return left(env) == right(env) }
+	case "!=":
+		return func(env *conditionEnv) bool { return left(env) != right(env) }
+	case "<":
+		return func(env *conditionEnv) bool { return left(env) < right(env) }
+	case "<=":
+		return func(env *conditionEnv) bool { return left(env) <= right(env) }
+	case ">":
+		return func(env *conditionEnv) bool { return left(env) > right(env) }
+	}
+	return func(env *conditionEnv) bool { return left(env) >= right(env) }
+}
+
+func (p *conditionParser) operand() (conditionValue, error) {
+	token := p.next()
+	if token == "" {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+	if token == "ip" {
+		return func(env *conditionEnv) int { return env.ip }, nil
+	}
+	if register, ok := p.register(token); ok {
+		return func(env *conditionEnv) int { return env.registers[register] }, nil
+	}
+	if strings.HasPrefix(token, "r") {
+		return nil, fmt.Errorf("no register %q on a device with %d registers", token, p.numRegisters)
+	}
+	value, err := strconv.Atoi(token)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return func(env *conditionEnv) int { return value }, nil
+}
+
+func (p *conditionParser) register(token string) (int, bool) {
+	if !strings.HasPrefix(token, "r") {
+		return 0, false
+	}
+	register, err := strconv.Atoi(token[1:])
+	if err != nil || register < 0 || register >= p.numRegisters {
+		return 0, false
+	}
+	return register, true
+}
@@ -0,0 +1,217 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RegisterValue is what constant propagation knows about a register.
+type RegisterValue struct {
+	Known bool
+	Value int
+}
+
+func (v RegisterValue) String() string {
+	if !v.Known {
+		return "?"
+	}
+	return strconv.Itoa(v.Value)
+}
+
+// Constants is the result of propagating register values through a program
+// from an initial state.
+type Constants struct {
+	Program *Program
+	// In holds the register values on entry to each instruction that holds
+	// whenever the run reaches it, or nil if the run never does.
+	In [][]RegisterValue
+	// Prelude lists the instructions the run executes, in order, before
+	// reaching Entry. Their states are all known, so each runs once.
+	Prelude []int
+	// Entry is the first instruction the run reaches with a register not
+	// known, which is where the main loop starts, or the instruction
+	// pointer on halting if it never does.
+	Entry int
+	// Registers holds the registers on reaching Entry.
+	Registers []int
+}
+
+// PropagateConstants works out which register values are fixed at each
+// instruction of a run starting with registers initial. Only the jumps the
+// known values allow are followed, so code the run cannot reach does not
+// spoil what is known elsewhere.
+func (p *Program) PropagateConstants(initial []int) (*Constants, error) {
+	if err := p.Validate(len(initial)); err != nil {
+		return nil, err
+	}
+	start := make([]RegisterValue, len(initial))
+	for r, value := range initial {
+		start[r] = RegisterValue{true, value}
+	}
+	in := p.propagate(start)
+	n := len(p.Instructions)
+
+	c := &Constants{Program: p, In: in}
+	registers := append([]int(nil), initial...)
+	ip := 0
+	executed := make([]bool, n)
+	for ip >= 0 && ip < n && allKnown(in[ip]) && !executed[ip] {
+		executed[ip] = true
+		c.Prelude = append(c.Prelude, ip)
+		registers[p.IP] = ip
+		opcode, _ := LookupOpcode(p.Instructions[ip].operation)
+		opcode.Execute(registers, p.Instructions[ip].a, p.Instructions[ip].b, p.Instructions[ip].c)
+		ip = registers[p.IP] + 1
+	}
+	c.Entry = ip
+	c.Registers = registers
+	return c, nil
+}
+
+// propagate returns the register values known on entry to each instruction
+// of a run starting from start, or nil for instructions it cannot reach.
+func (p *Program) propagate(start []RegisterValue) [][]RegisterValue {
+	n := len(p.Instructions)
+	in := make([][]RegisterValue, n)
+	var jumps []Jump
+	// merge meets state into the entry state of instruction i, reporting
+	// whether that changed anything.
+	merge := func(i int, state []RegisterValue) bool {
+		if i < 0 || i >= n {
+			return false
+		}
+		if in[i] == nil {
+			in[i] = append([]RegisterValue(nil), state...)
+			return true
+		}
+		changed := false
+		for r, value := range state {
+			if in[i][r].Known && in[i][r] != value {
+				in[i][r] = RegisterValue{}
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	if n == 0 {
+		return in
+	}
+	merge(0, start)
+	worklist := []int{0}
+	queued := make([]bool, n)
+	queued[0] = true
+	for len(worklist) > 0 {
+		i := worklist[0]
+		worklist = worklist[1:]
+		queued[i] = false
+		state := append([]RegisterValue(nil), in[i]...)
+		state[p.IP] = RegisterValue{true, i}
+		state[p.Instructions[i].c] = p.evaluateKnown(p.Instructions[i], state)
+		var targets []int
+		if ip := state[p.IP]; ip.Known {
+			targets = []int{ip.Value + 1}
+		} else {
+			if jumps == nil {
+				jumps = p.CFG().Jumps
+			}
+			targets = jumps[i].Targets
+			if jumps[i].Kind == UnresolvedJump {
+				targets = make([]int, n)
+				for j := range targets {
+					targets[j] = j
+				}
+			}
+		}
+		for _, target := range targets {
+			// Control only reaches target with the IP register holding
+			// target-1, whichever way the jump computed it.
+			state[p.IP] = RegisterValue{true, target - 1}
+			if merge(target, state) && !queued[target] {
+				queued[target] = true
+				worklist = append(worklist, target)
+			}
+		}
+	}
+	return in
+}
+
+// evaluateKnown returns the result of instruction when it depends only on
+// known values.
+func (p *Program) evaluateKnown(instruction Instruction, state []RegisterValue) RegisterValue {
+	kinds := operandKinds(instruction.operation)
+	operands := [2]int{instruction.a, instruction.b}
+	for i, kind := range kinds {
+		if kind != RegisterOperand {
+			continue
+		}
+		if !state[operands[i]].Known {
+			return RegisterValue{}
+		}
+		operands[i] = state[operands[i]].Value
+	}
+	return RegisterValue{true, apply(instruction.operation, operands[0], operands[1])}
+}
+
+func allKnown(state []RegisterValue) bool {
+	if state == nil {
+		return false
+	}
+	for _, value := range state {
+		if !value.Known {
+			return false
+		}
+	}
+	return true
+}
+
+// Fold returns a copy of the program in which every reachable instruction
+// whose result is known and that reads a register other than the IP
+// register sets the result directly. It behaves as the original for runs
+// from the state the constants were propagated from.
+func (c *Constants) Fold() *Program {
+	return c.Program.fold(c.In)
+}
+
+// fold replaces instructions whose result is known from in, the entry
+// states found by propagate, with seti.
+func (p *Program) fold(in [][]RegisterValue) *Program {
+	folded := p.copy()
+	for i, instruction := range p.Instructions {
+		if in[i] == nil || !p.readsData(instruction) {
+			continue
+		}
+		state := append([]RegisterValue(nil), in[i]...)
+		state[p.IP] = RegisterValue{true, i}
+		if result := p.evaluateKnown(instruction, state); result.Known {
+			folded.Instructions[i] = Instruction{"seti", result.Value, 0, instruction.c}
+		}
+	}
+	return folded
+}
+
+// readsData reports whether instruction reads a register other than the IP
+// register.
+func (p *Program) readsData(instruction Instruction) bool {
+	kinds := operandKinds(instruction.operation)
+	for i, operand := range [2]int{instruction.a, instruction.b} {
+		if kinds[i] == RegisterOperand && operand != p.IP {
+			return true
+		}
+	}
+	return false
+}
+
+// String lists the known register values on entry to each instruction.
+func (c *Constants) String() string {
+	var sb strings.Builder
+	for i, state := range c.In {
+		if state == nil {
+			sb.WriteString(fmt.Sprintf("%3d: unreachable\n", i))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%3d: %v\n", i, state))
+	}
+	return sb.String()
+}
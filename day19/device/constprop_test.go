@@ -0,0 +1,96 @@
+package device
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPropagateConstants(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(`#ip 4
+eqri 0 1 1
+addr 1 4 4
+seti 3 0 4
+seti 7 0 2
+addi 2 1 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r0          int
+		unreachable int
+		prelude     []int
+		registers   []int
+	}{
+		{0, 3, []int{0, 1, 2, 4}, []int{0, 0, 0, 1, 4}},
+		{1, 2, []int{0, 1, 3, 4}, []int{1, 1, 7, 8, 4}},
+	}
+	for _, test := range tests {
+		c, err := program.PropagateConstants([]int{test.r0, 0, 0, 0, 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.In[test.unreachable] != nil {
+			t.Errorf("r0 = %d: instruction %d reached with %v", test.r0, test.unreachable, c.In[test.unreachable])
+		}
+		if c.Entry != 5 || !reflect.DeepEqual(c.Prelude, test.prelude) || !reflect.DeepEqual(c.Registers, test.registers) {
+			t.Errorf("r0 = %d: got entry %d after %v with %v, want 5 after %v with %v", test.r0, c.Entry, c.Prelude, c.Registers, test.prelude, test.registers)
+		}
+	}
+}
+
+func TestPropagateConstantsDay19(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r0      int
+		target  int
+		prelude int
+	}{
+		{0, 981, 12},
+		{1, 10551381, 20},
+	}
+	for _, test := range tests {
+		initial := []int{test.r0, 0, 0, 0, 0, 0}
+		c, err := program.PropagateConstants(initial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Entry != 2 || len(c.Prelude) != test.prelude || c.Registers[5] != test.target {
+			t.Errorf("r0 = %d: got entry %d after %d instructions with %v", test.r0, c.Entry, len(c.Prelude), c.Registers)
+		}
+
+		d := New(6)
+		copy(d.Registers, initial)
+		if err := d.Load(program); err != nil {
+			t.Fatal(err)
+		}
+		for d.IP != c.Entry {
+			d.Step()
+		}
+		if d.Executed != len(c.Prelude) || !reflect.DeepEqual(d.Registers, c.Registers) {
+			t.Errorf("r0 = %d: device reached %d after %d instructions with %v", test.r0, c.Entry, d.Executed, d.Registers)
+		}
+
+		folded := c.Fold()
+		if folded.IP != program.IP || folded.Instructions[17] != NewInstruction("seti", 2, 0, 5) {
+			t.Errorf("r0 = %d: setup code not folded: %v", test.r0, folded.Instructions[17:])
+		}
+		var results [2]Result
+		for i, p := range []*Program{program, folded} {
+			d := New(6)
+			copy(d.Registers, initial)
+			if results[i], err = d.Execute(context.Background(), p, math.MaxInt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("r0 = %d: folded program gave %+v, want %+v", test.r0, results[1], results[0])
+		}
+	}
+}
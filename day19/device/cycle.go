@@ -0,0 +1,139 @@
+package device
+
+import (
+	"context"
+	"fmt"
+)
+
+// Cycle describes a loop in the states of a run: the state reached after
+// Start instructions, counted from Load, recurs every Length instructions.
+type Cycle struct {
+	Start, Length int
+}
+
+// cycleDetector looks for a repeated state with Brent's algorithm, so it
+// keeps just two states however long the run: the one the run started
+// from and the tortoise, which moves up to the current state at each power
+// of two observations.
+type cycleDetector struct {
+	startIP        int
+	startRegisters []int
+	ip             int // tortoise
+	registers      []int
+	executed       int
+	power, lambda  int
+}
+
+func newCycleDetector(ip int, registers []int) *cycleDetector {
+	return &cycleDetector{
+		startIP:        ip,
+		startRegisters: append([]int(nil), registers...),
+		ip:             ip,
+		registers:      append([]int(nil), registers...),
+		power:          1,
+		lambda:         1,
+	}
+}
+
+// observe is told the state after executed instructions of the run and
+// reports whether it is the tortoise's. States inside idioms run natively
+// are never observed, which only delays detection.
+func (c *cycleDetector) observe(ip int, registers []int, executed int) bool {
+	if ip == c.ip && equalRegisters(registers, c.registers) {
+		return true
+	}
+	if c.power == c.lambda {
+		c.ip = ip
+		copy(c.registers, registers)
+		c.executed = executed
+		c.power *= 2
+		c.lambda = 0
+	}
+	c.lambda++
+	return false
+}
+
+// cycleFound completes result, the state of a device stopped when observe
+// reported that the state after executed instructions of the run repeats,
+// with the cycle's start and length.
+func (d *Device) cycleFound(ctx context.Context, c *cycleDetector, executed int, result Result) Result {
+	length := executed - c.executed
+	start, err := d.cycleStart(ctx, c, length)
+	if err != nil {
+		result.Reason = Cancelled
+		result.Err = err
+		return result
+	}
+	result.Reason = CycleDetected
+	result.Cycle = &Cycle{result.Instructions - executed + start, length}
+	return result
+}
+
+// cycleStart returns how many instructions into the run the cycle of the
+// given length starts, replaying the run on replicas of the device. A
+// state that recurs after length instructions is followed by others that
+// do, so the first one is found by moving a replica from the start and one
+// length ahead in step, doubling the stride until they agree and then
+// halving it.
+func (d *Device) cycleStart(ctx context.Context, c *cycleDetector, length int) (int, error) {
+	tortoise := d.replica(c.startIP, c.startRegisters)
+	hare := d.replica(c.startIP, c.startRegisters)
+	if err := hare.advance(ctx, length); err != nil {
+		return 0, err
+	}
+	start, stride, overshot := 0, 1, false
+	for !tortoise.sameState(hare) {
+		t := tortoise.replica(tortoise.IP, tortoise.Registers)
+		h := hare.replica(hare.IP, hare.Registers)
+		if err := t.advance(ctx, stride); err != nil {
+			return 0, err
+		}
+		if err := h.advance(ctx, stride); err != nil {
+			return 0, err
+		}
+		if !t.sameState(h) {
+			tortoise, hare = t, h
+			start += stride
+			if !overshot {
+				stride *= 2
+			}
+			continue
+		}
+		if stride == 1 {
+			return start + 1, nil
+		}
+		overshot = true
+		stride /= 2
+	}
+	return start, nil
+}
+
+// replica returns a device running the same program from the given state.
+func (d *Device) replica(ip int, registers []int) *Device {
+	return &Device{
+		Registers: append([]int(nil), registers...),
+		IP:        ip,
+		Interpret: d.Interpret,
+		program:   d.program,
+		code:      d.code,
+		idioms:    d.idioms,
+		width:     d.width,
+	}
+}
+
+// advance executes exactly n instructions of a run already known to last
+// that long.
+func (d *Device) advance(ctx context.Context, n int) error {
+	result := d.RunContext(ctx, n)
+	switch result.Reason {
+	case BudgetExhausted:
+		return nil
+	case Cancelled:
+		return result.Err
+	}
+	panic(fmt.Sprintf("device: replaying a cycle stopped early: %v", result.Reason))
+}
+
+func (d *Device) sameState(other *Device) bool {
+	return d.IP == other.IP && equalRegisters(d.Registers, other.Registers)
+}
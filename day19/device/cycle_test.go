@@ -0,0 +1,116 @@
+package device
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectCycles(t *testing.T) {
+	const countdown = `#ip 0
+gtri 1 0 2
+addr 0 2 0
+seti 4 0 0
+addi 1 -1 1
+seti -1 0 0
+addi 3 1 3
+bani 3 3 3
+seti 4 0 0
+`
+	tests := []struct {
+		name      string
+		listing   string
+		registers []int
+		reason    HaltReason
+		cycle     *Cycle
+	}{
+		// The IP register still holds its initial 0 in the first state, so
+		// the cycle starts one instruction in.
+		{"counter", "#ip 0\naddi 1 1 1\nbani 1 7 1\nseti -1 0 0\n", []int{0, 0, 0, 0}, CycleDetected, &Cycle{1, 24}},
+		{"countdown", countdown, []int{0, 5, 0, 0}, CycleDetected, &Cycle{23, 12}},
+		{"no countdown", countdown, []int{0, 0, 0, 0}, CycleDetected, &Cycle{3, 12}},
+		{"self loop", "#ip 0\nseti -1 0 0\n", []int{0, 0, 0, 0}, CycleDetected, &Cycle{1, 1}},
+		{"halts", "#ip 0\naddi 1 1 1\ngtri 1 9 2\naddr 0 2 0\nseti -1 0 0\n", []int{0, 0, 0, 0}, Halted, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := ParseReader(strings.NewReader(test.listing))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, interpret := range []bool{false, true} {
+				d := New(4)
+				d.Interpret = interpret
+				d.DetectCycles = true
+				copy(d.Registers, test.registers)
+				result, err := d.Execute(context.Background(), program, 1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Reason != test.reason || !reflect.DeepEqual(result.Cycle, test.cycle) {
+					t.Errorf("interpret %v: got %v %+v, want %v %+v", interpret, result.Reason, result.Cycle, test.reason, test.cycle)
+				}
+			}
+		})
+	}
+}
+
+// stateAfter returns the state of a fresh run of program after n
+// instructions.
+func stateAfter(t *testing.T, program *Program, registers []int, n int) *Device {
+	d := New(len(registers))
+	copy(d.Registers, registers)
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	if result := d.Run(n); result.Reason != BudgetExhausted {
+		t.Fatalf("stopped after %d instructions: %v", result.Instructions, result.Reason)
+	}
+	return d
+}
+
+func TestDetectCyclesDay21(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(6)
+	d.DetectCycles = true
+	result, err := d.Execute(context.Background(), program, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reason != CycleDetected {
+		t.Fatalf("got %v", result.Reason)
+	}
+	cycle := result.Cycle
+	initial := make([]int, 6)
+	if !stateAfter(t, program, initial, cycle.Start).sameState(stateAfter(t, program, initial, cycle.Start+cycle.Length)) {
+		t.Errorf("state after %d instructions does not recur %d later", cycle.Start, cycle.Length)
+	}
+	if stateAfter(t, program, initial, cycle.Start-1).sameState(stateAfter(t, program, initial, cycle.Start-1+cycle.Length)) {
+		t.Errorf("cycle starts before instruction %d", cycle.Start)
+	}
+}
+
+func TestDetectCyclesAcrossRuns(t *testing.T) {
+	program, err := ParseReader(strings.NewReader("#ip 0\naddi 1 1 1\nbani 1 7 1\nseti -1 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(2)
+	d.DetectCycles = true
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	if result := d.Run(10); result.Reason != BudgetExhausted {
+		t.Fatalf("got %v", result.Reason)
+	}
+	// Detection starts afresh with each run; the start counts from Load.
+	result := d.Continue()
+	if result.Reason != CycleDetected || *result.Cycle != (Cycle{10, 24}) {
+		t.Errorf("got %v %+v", result.Reason, result.Cycle)
+	}
+}
@@ -0,0 +1,586 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Decompile renders the program as structured pseudo-Go. Loops become for
+// statements, comparisons that feed a jump become if statements, and
+// registers are named r0, r1, ... with reads of the IP register replaced by
+// the instruction index. A comparison whose result is kept is written
+// b2i(x > y), which is 1 when the comparison holds and 0 otherwise.
+// Control flow that does not fit those shapes falls back to labels and
+// gotos. An unresolved jump is shown as a goto to its computed target, and
+// code only reachable that way starts a new labelled section. The program
+// must be valid.
+func (p *Program) Decompile() string {
+	d := newDecompiler(p)
+	var stmts []stmt
+	for _, block := range d.cfg.Blocks {
+		if d.visited[block.ID] {
+			continue
+		}
+		if block.Start > 0 {
+			d.labelled[block.Start] = true
+		}
+		stmts = append(stmts, d.sequence(block, nil, nil)...)
+	}
+	stmts = d.tidy(stmts)
+
+	var sb strings.Builder
+	sb.WriteString("func run() {\n")
+	d.render(&sb, stmts, 1)
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+type stmtKind int
+
+const (
+	simpleStmt stmtKind = iota
+	labelStmt
+	// jumpStmt is a simple statement after which control does not fall
+	// through: break, continue, return or goto.
+	jumpStmt
+	ifStmt
+	forStmt
+	switchStmt
+)
+
+// stmt is a node of the decompiled syntax tree.
+type stmt struct {
+	kind stmtKind
+	text string
+	// ip is the instruction a label marks.
+	ip   int
+	cond comparison
+	// body holds the if or for body; els holds the else branch.
+	body, els []stmt
+	cases     []switchCase
+}
+
+type switchCase struct {
+	value int
+	body  []stmt
+}
+
+// comparison is a condition. An empty op means an infinite loop.
+type comparison struct {
+	left, op, right string
+}
+
+func (c comparison) negate() comparison {
+	switch c.op {
+	case "==":
+		c.op = "!="
+	case "!=":
+		c.op = "=="
+	case ">":
+		c.op = "<="
+	case "<=":
+		c.op = ">"
+	}
+	return c
+}
+
+func (c comparison) String() string {
+	return c.left + " " + c.op + " " + c.right
+}
+
+// loop is a natural loop: the blocks that can reach a back edge to header
+// without passing through it.
+type loop struct {
+	header *Block
+	body   []bool
+	// follow is where control continues once the loop exits, or nil if it
+	// only leaves by halting.
+	follow *Block
+}
+
+type decompiler struct {
+	program *Program
+	cfg     *CFG
+	// liveOut[i][r] is set when register r may be read after instruction i.
+	liveOut [][]bool
+	// loops holds the loop headed by each block, if any.
+	loops []*loop
+	// ipdom holds the immediate post-dominator of each block, or -1.
+	ipdom    []int
+	visited  []bool
+	entered  []bool
+	labelled map[int]bool
+}
+
+func newDecompiler(p *Program) *decompiler {
+	g := p.CFG()
+	d := &decompiler{
+		program:  p,
+		cfg:      g,
+		loops:    make([]*loop, len(g.Blocks)),
+		visited:  make([]bool, len(g.Blocks)),
+		entered:  make([]bool, len(g.Blocks)),
+		labelled: make(map[int]bool),
+	}
+	d.findLoops()
+	d.findPostDominators()
+	d.liveOut = d.cfg.liveOut()
+	return d
+}
+
+// findLoops finds the natural loops. The program is entered at block 0 and,
+// through unresolved jumps, at any block not otherwise reachable.
+func (d *decompiler) findLoops() {
+	blocks := d.cfg.Blocks
+	n := len(blocks)
+	root := make([]bool, n)
+	reached := make([]bool, n)
+	var reach func(b *Block)
+	reach = func(b *Block) {
+		if reached[b.ID] {
+			return
+		}
+		reached[b.ID] = true
+		for _, succ := range b.Succs {
+			reach(succ)
+		}
+	}
+	for _, b := range blocks {
+		if !reached[b.ID] {
+			root[b.ID] = true
+			reach(b)
+		}
+	}
+
+	dom := make([][]bool, n)
+	for i := range dom {
+		dom[i] = make([]bool, n)
+		for j := range dom[i] {
+			dom[i][j] = !root[i] || i == j
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range blocks {
+			if root[b.ID] {
+				continue
+			}
+			for j := range dom[b.ID] {
+				in := j == b.ID
+				if !in {
+					in = true
+					for _, pred := range b.Preds {
+						in = in && dom[pred.ID][j]
+					}
+				}
+				if dom[b.ID][j] != in {
+					dom[b.ID][j] = in
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, tail := range blocks {
+		for _, header := range tail.Succs {
+			if !dom[tail.ID][header.ID] {
+				continue
+			}
+			l := d.loops[header.ID]
+			if l == nil {
+				l = &loop{header: header, body: make([]bool, n)}
+				l.body[header.ID] = true
+				d.loops[header.ID] = l
+			}
+			var collect func(b *Block)
+			collect = func(b *Block) {
+				if l.body[b.ID] {
+					return
+				}
+				l.body[b.ID] = true
+				for _, pred := range b.Preds {
+					collect(pred)
+				}
+			}
+			collect(tail)
+		}
+	}
+	for _, l := range d.loops {
+		if l == nil {
+			continue
+		}
+		for id, in := range l.body {
+			if !in {
+				continue
+			}
+			for _, succ := range blocks[id].Succs {
+				if !l.body[succ.ID] && (l.follow == nil || succ.Start < l.follow.Start) {
+					l.follow = succ
+				}
+			}
+		}
+	}
+}
+
+// findPostDominators finds the immediate post-dominator of each block, the
+// point where the branches of a conditional meet again.
+func (d *decompiler) findPostDominators() {
+	blocks := d.cfg.Blocks
+	n := len(blocks)
+	exit := n
+	exits := func(b *Block) bool { return b.Halts || b.Unresolved }
+	pdom := make([][]bool, n+1)
+	for i := range pdom {
+		pdom[i] = make([]bool, n+1)
+		for j := range pdom[i] {
+			pdom[i][j] = i != exit || j == exit
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			b := blocks[i]
+			for j := range pdom[i] {
+				in := j == i
+				if !in {
+					in = !exits(b) || pdom[exit][j]
+					for _, succ := range b.Succs {
+						in = in && pdom[succ.ID][j]
+					}
+				}
+				if pdom[i][j] != in {
+					pdom[i][j] = in
+					changed = true
+				}
+			}
+		}
+	}
+
+	d.ipdom = make([]int, n)
+	for i := range blocks {
+		d.ipdom[i] = -1
+		// Blocks that never reach the exit keep every block as a
+		// post-dominator, so there is no meaningful merge point.
+		if !pdom[i][exit] {
+			continue
+		}
+		best := -1
+		size := func(j int) int {
+			count := 0
+			for _, in := range pdom[j] {
+				if in {
+					count++
+				}
+			}
+			return count
+		}
+		for j := 0; j < n; j++ {
+			if j != i && pdom[i][j] && (best < 0 || size(j) > size(best)) {
+				best = j
+			}
+		}
+		d.ipdom[i] = best
+	}
+}
+
+// sequence decompiles the code starting at block b until it reaches stop or
+// leaves the innermost loop.
+func (d *decompiler) sequence(b *Block, stop *Block, inner *loop) []stmt {
+	var stmts []stmt
+	for b != nil {
+		if l := d.loops[b.ID]; l != nil && !d.entered[b.ID] {
+			d.entered[b.ID] = true
+			stmts = append(stmts, stmt{kind: forStmt, body: d.sequence(b, nil, l)})
+			if l.follow == nil {
+				return stmts
+			}
+			var jump []stmt
+			b, jump = d.branch(l.follow.Start, stop, inner)
+			stmts = append(stmts, jump...)
+			continue
+		}
+		d.visited[b.ID] = true
+		stmts = append(stmts, stmt{kind: labelStmt, ip: b.Start})
+
+		end := b.End
+		jump := d.cfg.Jumps[end]
+		cond, folded := d.condition(b)
+		if folded {
+			end--
+		}
+		for i := b.Start; i < end; i++ {
+			stmts = append(stmts, d.statement(i)...)
+		}
+		if jump.Kind == Fallthrough {
+			stmts = append(stmts, d.statement(end)...)
+		} else if folded && d.liveOut[b.End][d.program.Instructions[end].c] {
+			// The comparison result is read again later, so keep it.
+			stmts = append(stmts, d.statement(end)...)
+			flag := d.program.Instructions[end].c
+			cond = comparison{fmt.Sprintf("r%d", flag), "==", "1"}
+		}
+
+		var tail []stmt
+		switch jump.Kind {
+		case Fallthrough, ConstantJump:
+			b, tail = d.branch(jump.Targets[0], stop, inner)
+		case UnresolvedJump:
+			tail = []stmt{{kind: jumpStmt, text: "goto " + d.nextIP(b.End)}}
+			b = nil
+		case ComputedJump:
+			var merge *Block
+			if m := d.ipdom[b.ID]; m >= 0 && (inner == nil || inner.body[m]) {
+				merge = d.cfg.Blocks[m]
+			}
+			arm := func(target int) []stmt {
+				next, stmts := d.branch(target, merge, inner)
+				if next != nil {
+					stmts = d.sequence(next, merge, inner)
+				}
+				return stmts
+			}
+			if folded {
+				tail = []stmt{{kind: ifStmt, cond: cond, body: arm(b.End + 2), els: arm(b.End + 1)}}
+			} else {
+				s := stmt{kind: switchStmt, text: d.nextIP(b.End)}
+				for _, target := range jump.Targets {
+					s.cases = append(s.cases, switchCase{target, arm(target)})
+				}
+				tail = []stmt{s}
+			}
+			b = nil
+			if merge != nil {
+				var jump []stmt
+				b, jump = d.branch(merge.Start, stop, inner)
+				tail = append(tail, jump...)
+			}
+		}
+		stmts = append(stmts, tail...)
+	}
+	return stmts
+}
+
+// branch decides how control passing to instruction target is expressed:
+// either as a statement ending the current sequence or by carrying on with
+// the block it returns.
+func (d *decompiler) branch(target int, stop *Block, inner *loop) (*Block, []stmt) {
+	if target < 0 || target >= len(d.program.Instructions) {
+		return nil, []stmt{{kind: jumpStmt, text: "return"}}
+	}
+	b := d.cfg.BlockOf(target)
+	switch {
+	case b == stop:
+		return nil, nil
+	case inner != nil && b == inner.header:
+		return nil, []stmt{{kind: jumpStmt, text: "continue"}}
+	case inner != nil && b == inner.follow:
+		return nil, []stmt{{kind: jumpStmt, text: "break"}}
+	case d.visited[b.ID] || d.entered[b.ID] || (inner != nil && !inner.body[b.ID]):
+		d.labelled[b.Start] = true
+		return nil, []stmt{{kind: jumpStmt, text: fmt.Sprintf("goto I%d", b.Start)}}
+	}
+	return b, nil
+}
+
+// condition reports whether block b ends in a comparison whose result is
+// added to the IP register, and returns the comparison if so.
+func (d *decompiler) condition(b *Block) (comparison, bool) {
+	p := d.program
+	jump := d.cfg.Jumps[b.End]
+	if jump.Kind != ComputedJump || b.End == b.Start || len(jump.Targets) != 2 ||
+		jump.Targets[0] != b.End+1 || jump.Targets[1] != b.End+2 {
+		return comparison{}, false
+	}
+	add := p.Instructions[b.End]
+	compare := p.Instructions[b.End-1]
+	flag := compare.c
+	if add.operation != "addr" || flag == p.IP || !isComparison(compare.operation) ||
+		!(add.a == p.IP && add.b == flag || add.a == flag && add.b == p.IP) {
+		return comparison{}, false
+	}
+	a, b2 := d.operands(b.End - 1)
+	return comparison{a, basicOpcodes[compare.operation].operator, b2}, true
+}
+
+// operands renders the a and b operands of instruction i.
+func (d *decompiler) operands(i int) (string, string) {
+	instruction := d.program.Instructions[i]
+	kinds := operandKinds(instruction.operation)
+	var rendered [2]string
+	for j, value := range [2]int{instruction.a, instruction.b} {
+		switch {
+		case kinds[j] != RegisterOperand:
+			rendered[j] = strconv.Itoa(value)
+		case value == d.program.IP:
+			rendered[j] = strconv.Itoa(i)
+		default:
+			rendered[j] = fmt.Sprintf("r%d", value)
+		}
+	}
+	return rendered[0], rendered[1]
+}
+
+// expression renders the value instruction i computes. Opcodes other than
+// the built-in ones are shown as calls.
+func (d *decompiler) expression(i int) string {
+	operation := d.program.Instructions[i].operation
+	a, b := d.operands(i)
+	opcode, ok := basicOpcodes[operation]
+	switch {
+	case !ok:
+		return fmt.Sprintf("%s(%s, %s)", operation, a, b)
+	case opcode.operator == "":
+		return a
+	case opcode.comparison():
+		return fmt.Sprintf("b2i(%s %s %s)", a, opcode.operator, b)
+	}
+	return fmt.Sprintf("%s %s %s", a, opcode.operator, b)
+}
+
+// statement renders instruction i, which does not jump.
+func (d *decompiler) statement(i int) []stmt {
+	instruction := d.program.Instructions[i]
+	c := fmt.Sprintf("r%d", instruction.c)
+	a, b := d.operands(i)
+	opcode, ok := basicOpcodes[instruction.operation]
+	arithmetic := ok && opcode.operator != "" && !opcode.comparison()
+	if arithmetic && b == c {
+		a, b = b, a
+	}
+	text := fmt.Sprintf("%s = %s", c, d.expression(i))
+	switch {
+	case arithmetic && a == c && opcode.operator == "+" && b == "1":
+		text = c + "++"
+	case arithmetic && a == c:
+		text = fmt.Sprintf("%s %s= %s", c, opcode.operator, b)
+	}
+	return []stmt{{kind: simpleStmt, text: text}}
+}
+
+// nextIP renders the next instruction index after jump instruction i.
+func (d *decompiler) nextIP(i int) string {
+	instruction := d.program.Instructions[i]
+	a, b := d.operands(i)
+	if instruction.operation == "addr" || instruction.operation == "addi" {
+		if a == strconv.Itoa(i) {
+			a, b = b, a
+		}
+		if b == strconv.Itoa(i) {
+			return fmt.Sprintf("%s + %d", a, i+1)
+		}
+	}
+	return fmt.Sprintf("(%s) + 1", d.expression(i))
+}
+
+// tidy simplifies the tree: else branches after a branch that always jumps
+// are hoisted, empty branches removed, trailing continues dropped and loops
+// that test first turned into conditional for statements.
+func (d *decompiler) tidy(stmts []stmt) []stmt {
+	var out []stmt
+	for _, s := range stmts {
+		s.body = d.tidy(s.body)
+		s.els = d.tidy(s.els)
+		for i := range s.cases {
+			s.cases[i].body = d.tidy(s.cases[i].body)
+		}
+		switch s.kind {
+		case ifStmt:
+			if d.empty(s.els) {
+				s.els = nil
+			}
+			if d.empty(s.body) {
+				s.cond, s.body, s.els = s.cond.negate(), s.els, nil
+			}
+			if len(s.body) == 0 {
+				continue
+			}
+			if last := s.body[len(s.body)-1]; last.kind == jumpStmt && len(s.els) > 0 {
+				els := s.els
+				s.els = nil
+				out = append(out, s)
+				out = append(out, els...)
+				continue
+			}
+		case forStmt:
+			if n := len(s.body); n > 0 && s.body[n-1].kind == jumpStmt && s.body[n-1].text == "continue" {
+				s.body = s.body[:n-1]
+			}
+			if first := d.firstStatement(s.body); first >= 0 {
+				test := s.body[first]
+				if test.kind == ifStmt && len(test.els) == 0 && len(test.body) == 1 && test.body[0].text == "break" {
+					s.cond = test.cond.negate()
+					s.body = append(s.body[:first:first], s.body[first+1:]...)
+				}
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// empty reports whether stmts holds nothing but labels that are never
+// jumped to.
+func (d *decompiler) empty(stmts []stmt) bool {
+	return d.firstStatement(stmts) < 0 && !d.hasLabel(stmts)
+}
+
+func (d *decompiler) hasLabel(stmts []stmt) bool {
+	for _, s := range stmts {
+		if s.kind == labelStmt && d.labelled[s.ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// firstStatement returns the index of the first statement, skipping labels
+// that are never jumped to, or -1 if there is none or a label is in the way.
+func (d *decompiler) firstStatement(stmts []stmt) int {
+	for i, s := range stmts {
+		if s.kind != labelStmt {
+			return i
+		}
+		if d.labelled[s.ip] {
+			return -1
+		}
+	}
+	return -1
+}
+
+func (d *decompiler) render(sb *strings.Builder, stmts []stmt, depth int) {
+	indent := strings.Repeat("\t", depth)
+	for _, s := range stmts {
+		switch s.kind {
+		case labelStmt:
+			if d.labelled[s.ip] {
+				sb.WriteString(fmt.Sprintf("%sI%d:\n", indent[1:], s.ip))
+			}
+		case simpleStmt, jumpStmt:
+			sb.WriteString(indent + s.text + "\n")
+		case ifStmt:
+			sb.WriteString(fmt.Sprintf("%sif %v {\n", indent, s.cond))
+			d.render(sb, s.body, depth+1)
+			if len(s.els) > 0 {
+				sb.WriteString(indent + "} else {\n")
+				d.render(sb, s.els, depth+1)
+			}
+			sb.WriteString(indent + "}\n")
+		case forStmt:
+			if s.cond.op == "" {
+				sb.WriteString(indent + "for {\n")
+			} else {
+				sb.WriteString(fmt.Sprintf("%sfor %v {\n", indent, s.cond))
+			}
+			d.render(sb, s.body, depth+1)
+			sb.WriteString(indent + "}\n")
+		case switchStmt:
+			sb.WriteString(fmt.Sprintf("%sswitch %s {\n", indent, s.text))
+			for _, c := range s.cases {
+				sb.WriteString(fmt.Sprintf("%scase %d:\n", indent, c.value))
+				d.render(sb, c.body, depth+1)
+			}
+			sb.WriteString(indent + "}\n")
+		}
+	}
+}
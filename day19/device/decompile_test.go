@@ -0,0 +1,154 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecompile(t *testing.T) {
+	var tests = []struct {
+		name     string
+		listing  string
+		expected string
+	}{
+		{"while loop", `#ip 5
+seti 0 0 1
+gtri 1 9 2
+addr 2 5 5
+addi 5 2 5
+seti 0 0 2
+seti 99 0 5
+addr 0 1 0
+addi 1 1 1
+seti 0 0 5
+`, `func run() {
+	r1 = 0
+	for r1 <= 9 {
+		r0 += r1
+		r1++
+	}
+	r2 = 0
+	return
+}
+`},
+		{"two entries into a loop", `#ip 5
+eqri 0 0 1
+addr 1 5 5
+seti 3 0 5
+addi 0 1 0
+addi 0 1 0
+gtri 0 9 1
+addr 1 5 5
+seti 2 0 5
+`, `func run() {
+	if r0 == 0 {
+	I3:
+		r0++
+	}
+	r0++
+	r1 = b2i(r0 > 9)
+	if r1 == 1 {
+		return
+	}
+	goto I3
+}
+`},
+		{"switch", `#ip 5
+gtri 0 5 1
+muli 1 2 1
+addr 1 5 5
+seti 7 0 2
+seti 8 0 3
+seti 9 0 4
+`, `func run() {
+	r1 = b2i(r0 > 5)
+	r1 *= 2
+	switch r1 + 3 {
+	case 3:
+		r2 = 7
+		r3 = 8
+	case 5:
+	}
+	r4 = 9
+	return
+}
+`},
+	}
+	for _, test := range tests {
+		program, err := ParseReader(strings.NewReader(test.listing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := program.Decompile(); actual != test.expected {
+			t.Errorf("%s: Decompile() =\n%s\nexpected\n%s", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestDecompileDay21(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `func run() {
+	r4 = 123
+	for {
+		r4 &= 456
+		r4 = b2i(r4 == 72)
+		if r4 == 1 {
+			break
+		}
+	}
+	r4 = 0
+	for {
+		r3 = r4 | 65536
+		r4 = 16098955
+		for {
+			r5 = r3 & 255
+			r4 += r5
+			r4 &= 16777215
+			r4 *= 65899
+			r4 &= 16777215
+			if 256 > r3 {
+				break
+			}
+			r5 = 0
+			for {
+				r2 = r5 + 1
+				r2 *= 256
+				r2 = b2i(r2 > r3)
+				if r2 == 1 {
+					break
+				}
+				r5++
+			}
+			r3 = r5
+		}
+		r5 = b2i(r4 == r0)
+		if r5 == 1 {
+			return
+		}
+	}
+}
+`
+	if actual := program.Decompile(); actual != expected {
+		t.Errorf("Decompile() =\n%s\nexpected\n%s", actual, expected)
+	}
+}
+
+func TestDecompileDay19(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := program.Decompile()
+	for _, expected := range []string{
+		"\tgoto r0 + 26\nI1:\n\tr4 = 1\n\tfor {\n\t\tr2 = 1\n\t\tfor {\n",
+		"\t\t\tr1 = r4 * r2\n\t\t\tif r1 == r5 {\n\t\t\t\tr0 += r4\n\t\t\t}\n\t\t\tr2++\n\t\t\tif r2 > r5 {\n\t\t\t\tbreak\n\t\t\t}\n\t\t}\n",
+		"I26:\n\tgoto I1\nI27:\n\tr1 = 27\n",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("Decompile() =\n%s\nexpected it to contain\n%s", actual, expected)
+		}
+	}
+}
@@ -1,78 +1,256 @@
 package device
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Device is a register machine that runs a loaded Program. The instruction
+// pointer and instruction count live on the device so that a run can be
+// paused and resumed.
 type Device struct {
 	Registers []int
+	IP        int // instruction pointer of the next instruction
+	Executed  int // instructions executed since the program was loaded
+	// Tracer, if set, is told about every executed instruction.
+	Tracer Tracer
+	// Interpret makes the device look up each operation by name as it runs
+	// instead of using the program compiled by Load and the loop idioms
+	// found in it. It is much slower and serves as a reference for the
+	// compiled form.
+	Interpret bool
+	// DetectCycles makes a run stop with CycleDetected once the device
+	// returns to a state, that is an instruction pointer and registers, it
+	// was in earlier in the run, which proves the program never halts.
+	// Detection needs constant memory and takes at most a few times as
+	// many instructions as the cycle's start and length.
+	DetectCycles bool
+	program      *Program
+	code         []compiled
+	idioms       []*idiomMatch // by entry instruction
+	width        *width        // nil for native ints
+	before       []int         // registers before the traced instruction
+
+	breakpoints map[int][]*Breakpoint // by instruction
+	watchpoints map[int][]*Watchpoint // by register
+	nextID      int
+	// stoppedAtBreakpoint records that the last run stopped at a breakpoint
+	// on instruction breakpointIP, so the next run starting there steps past
+	// it instead of stopping again.
+	stoppedAtBreakpoint bool
+	breakpointIP        int
+}
+
+var ErrNoProgram = errors.New("no program loaded")
+
+// New returns a device with numRegisters registers, which are Go ints
+// unless options say otherwise. It panics if the options are invalid.
+func New(numRegisters int, options ...Option) *Device {
+	d, err := newDevice(numRegisters, options)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// newDevice is New, returning an error where New panics.
+func newDevice(numRegisters int, options []Option) (*Device, error) {
+	if numRegisters < 0 {
+		return nil, fmt.Errorf("device: %d registers", numRegisters)
+	}
+	var c config
+	for _, option := range options {
+		option(&c)
+	}
+	w, err := newWidth(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{Registers: make([]int, numRegisters), width: w}, nil
+}
+
+// Load validates program against the register file and makes it the
+// device's program, resetting the instruction pointer and count. The
+// registers are left alone so that callers may set initial values.
+func (d *Device) Load(program *Program) error {
+	if err := program.Validate(len(d.Registers)); err != nil {
+		return err
+	}
+	d.program = program
+	d.code = program.compile()
+	d.idioms = program.findIdioms()
+	d.IP = 0
+	d.Executed = 0
+	d.stoppedAtBreakpoint = false
+	return nil
 }
 
-func New(numRegisters int) *Device {
-	return &Device{make([]int, numRegisters)}
+// Program returns the loaded program, or nil.
+func (d *Device) Program() *Program {
+	return d.program
 }
 
-func (d Device) Execute(program *Program, maxInstructions int) int {
-	instructionPointer := 0
-	instructionsExecuted := 0
-	for instructionsExecuted < maxInstructions && instructionPointer < len(program.Instructions) {
-		d.Registers[program.IP] = instructionPointer
-		instruction := program.Instructions[instructionPointer]
-		//fmt.Printf("ip=%d %v %v %d %d %d ", instructionPointer, d.Registers, instruction.operation, instruction.a, instruction.b, instruction.c)
-		operations[instruction.operation](d.Registers, instruction.a, instruction.b, instruction.c)
-		//fmt.Printf("%v\n", d.Registers)
-		instructionPointer = d.Registers[program.IP]
-		instructionPointer++
-		instructionsExecuted++
+// HaltReason tells why a device stopped executing.
+type HaltReason int
+
+const (
+	// Halted means the instruction pointer left the program.
+	Halted HaltReason = iota
+	// BudgetExhausted means the instruction budget ran out first.
+	BudgetExhausted
+	// Cancelled means the context was cancelled.
+	Cancelled
+	// Fault means the program could not be executed; Result.Err says why.
+	Fault
+	// BreakpointHit means a breakpoint fired; see Result.Breakpoint.
+	BreakpointHit
+	// WatchpointHit means a watched register was written; see
+	// Result.Watchpoint.
+	WatchpointHit
+	// CycleDetected means the device returned to an earlier state; see
+	// Result.Cycle.
+	CycleDetected
+)
+
+func (r HaltReason) String() string {
+	switch r {
+	case Halted:
+		return "halted"
+	case BudgetExhausted:
+		return "budget exhausted"
+	case Cancelled:
+		return "cancelled"
+	case Fault:
+		return "fault"
+	case BreakpointHit:
+		return "breakpoint"
+	case WatchpointHit:
+		return "watchpoint"
+	case CycleDetected:
+		return "cycle detected"
 	}
-	return instructionsExecuted
+	return fmt.Sprintf("HaltReason(%d)", int(r))
 }
 
-var operations = map[string]func(registers []int, a, b, c int){
-	"addr": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] + registers[b]
-	},
-	"addi": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] + b
-	},
-	"mulr": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] * registers[b]
-	},
-	"muli": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] * b
-	},
-	"banr": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] & registers[b]
-	},
-	"bani": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] & b
-	},
-	"borr": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] | registers[b]
-	},
-	"bori": func(registers []int, a, b, c int) {
-		registers[c] = registers[a] | b
-	},
-	"setr": func(registers []int, a, b, c int) {
-		registers[c] = registers[a]
-	},
-	"seti": func(registers []int, a, b, c int) {
-		registers[c] = a
-	},
-	"gtir": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(a > registers[b])
-	},
-	"gtri": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(registers[a] > b)
-	},
-	"gtrr": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(registers[a] > registers[b])
-	},
-	"eqir": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(a == registers[b])
-	},
-	"eqri": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(registers[a] == b)
-	},
-	"eqrr": func(registers []int, a, b, c int) {
-		registers[c] = boolToInt(registers[a] == registers[b])
-	},
+// Result describes the state of a device when execution stopped.
+type Result struct {
+	Reason       HaltReason
+	IP           int // instruction pointer of the next instruction
+	Instructions int // instructions executed since the program was loaded
+	Registers    []int
+	Err          error // cause of a Fault, or the context's error if Cancelled
+	Breakpoint   *Breakpoint
+	Watchpoint   *Watchpoint
+	Cycle        *Cycle
+}
+
+// cancelCheckInterval is how many instructions run between checks of the
+// context, so that cancellation stays cheap on long runs.
+const cancelCheckInterval = 1 << 12
+
+// Execute loads program and runs it until the instruction pointer leaves
+// it, maxInstructions have been executed or ctx is cancelled. If the program
+// fails validation the result has reason Fault and the validation error is
+// also returned.
+func (d *Device) Execute(ctx context.Context, program *Program, maxInstructions int) (Result, error) {
+	if err := d.Load(program); err != nil {
+		d.program = nil
+		return d.result(Fault, err), err
+	}
+	return d.RunContext(ctx, maxInstructions), nil
+}
+
+// Step executes a single instruction.
+func (d *Device) Step() Result {
+	return d.RunContext(context.Background(), 1)
+}
+
+// Run executes at most n instructions.
+func (d *Device) Run(n int) Result {
+	return d.RunContext(context.Background(), n)
+}
+
+// Continue runs until the program halts.
+func (d *Device) Continue() Result {
+	return d.RunContext(context.Background(), math.MaxInt)
+}
+
+// RunContext executes at most n instructions of the loaded program, stopping
+// early if it halts or ctx is cancelled.
+func (d *Device) RunContext(ctx context.Context, n int) Result {
+	program := d.program
+	if program == nil {
+		return d.result(Fault, ErrNoProgram)
+	}
+	skipBreakpoint := d.stoppedAtBreakpoint && d.breakpointIP == d.IP
+	d.stoppedAtBreakpoint = false
+	var cycles *cycleDetector
+	if d.DetectCycles {
+		cycles = newCycleDetector(d.IP, d.Registers)
+	}
+	if d.Tracer == nil && len(d.breakpoints) == 0 && len(d.watchpoints) == 0 && !d.Interpret && d.width == nil {
+		return d.runCompiled(ctx, n, cycles)
+	}
+	for executed := 0; ; executed++ {
+		if cycles != nil && executed > 0 && cycles.observe(d.IP, d.Registers, executed) {
+			return d.cycleFound(ctx, cycles, executed, d.result(CycleDetected, nil))
+		}
+		if d.IP < 0 || d.IP >= len(program.Instructions) {
+			return d.result(Halted, nil)
+		}
+		if executed >= n {
+			return d.result(BudgetExhausted, nil)
+		}
+		if executed%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return d.result(Cancelled, ctx.Err())
+		}
+		// Breakpoint conditions see the IP register as the instruction will.
+		d.Registers[program.IP] = d.IP
+		if len(d.breakpoints) > 0 && !skipBreakpoint {
+			if breakpoint := d.breakpointAt(); breakpoint != nil {
+				d.stoppedAtBreakpoint = true
+				d.breakpointIP = d.IP
+				result := d.result(BreakpointHit, nil)
+				result.Breakpoint = breakpoint
+				return result
+			}
+		}
+		skipBreakpoint = false
+		instruction := program.Instructions[d.IP]
+		if d.Tracer != nil {
+			d.before = append(d.before[:0], d.Registers...)
+		}
+		if d.width != nil {
+			if !d.width.execute(d.Registers, instruction) {
+				return d.result(Fault, &OverflowError{d.IP, instruction, d.width.bits, d.width.unsigned})
+			}
+		} else if d.Interpret {
+			opcode, _ := LookupOpcode(instruction.operation)
+			opcode.Execute(d.Registers, instruction.a, instruction.b, instruction.c)
+		} else {
+			d.code[d.IP](d.Registers)
+		}
+		d.Executed++
+		if d.Tracer != nil {
+			d.Tracer.Trace(&TraceRecord{d.Executed, d.IP, instruction, d.before, d.Registers})
+		}
+		d.IP = d.Registers[program.IP] + 1
+		if len(d.watchpoints) > 0 {
+			if watchpoint := d.watchpointOn(instruction.c); watchpoint != nil {
+				result := d.result(WatchpointHit, nil)
+				result.Watchpoint = watchpoint
+				return result
+			}
+		}
+	}
+}
+
+func (d *Device) result(reason HaltReason, err error) Result {
+	registers := make([]int, len(d.Registers))
+	copy(registers, d.Registers)
+	return Result{Reason: reason, IP: d.IP, Instructions: d.Executed, Registers: registers, Err: err}
 }
 
 func boolToInt(b bool) int {
@@ -82,4 +260,3 @@ func boolToInt(b bool) int {
 		return 0
 	}
 }
-
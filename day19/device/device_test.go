@@ -1,6 +1,7 @@
 package device
 
 import (
+	"context"
 	"math"
 	"testing"
 )
@@ -36,10 +37,19 @@ func TestInstructions(t *testing.T) {
 	for _, test := range tests {
 		registers := make([]int, len(test.registersBefore))
 		copy(registers, test.registersBefore)
-		operations[test.instruction](registers, test.args[0], test.args[1], test.args[2])
+		opcode, ok := LookupOpcode(test.instruction)
+		if !ok {
+			t.Fatalf("Opcode %q is not registered", test.instruction)
+		}
+		opcode.Execute(registers, test.args[0], test.args[1], test.args[2])
 		if !equal(registers, test.registersAfter) {
 			t.Errorf("%q(%v, %v) = %v", test.instruction, test.registersBefore, test.args, test.registersAfter)
 		}
+		copy(registers, test.registersBefore)
+		Instruction{test.instruction, test.args[0], test.args[1], test.args[2]}.compile()(registers)
+		if !equal(registers, test.registersAfter) {
+			t.Errorf("compiled %q(%v, %v) = %v", test.instruction, test.registersBefore, test.args, registers)
+		}
 	}
 }
 
@@ -56,10 +66,22 @@ func TestExecute(t *testing.T) {
 		},
 	}
 
-	testDevice := New(6)
-	testDevice.Execute(&program, math.MaxInt32)
-	if !equal(testDevice.Registers, []int{6, 5, 6, 0, 0, 9}) {
-		t.Errorf("Final registers %v not equal to expected", testDevice.Registers)
+	for _, interpret := range []bool{false, true} {
+		testDevice := New(6)
+		testDevice.Interpret = interpret
+		result, err := testDevice.Execute(context.Background(), &program, math.MaxInt32)
+		if err != nil {
+			t.Fatalf("Execute returned error %v", err)
+		}
+		if result.Reason != Halted || result.IP != 7 || result.Instructions != 5 {
+			t.Errorf("Execute result (interpret %v) = %v at ip %d after %d instructions", interpret, result.Reason, result.IP, result.Instructions)
+		}
+		if !equal(result.Registers, testDevice.Registers) {
+			t.Errorf("Result registers %v not equal to device registers %v", result.Registers, testDevice.Registers)
+		}
+		if !equal(testDevice.Registers, []int{6, 5, 6, 0, 0, 9}) {
+			t.Errorf("Final registers (interpret %v) %v not equal to expected", interpret, testDevice.Registers)
+		}
 	}
 }
 
@@ -73,4 +95,89 @@ func equal(a, b []int) bool {
 		}
 	}
 	return true
-}
\ No newline at end of file
+}
+
+func TestExecuteHaltReasons(t *testing.T) {
+	loop := &Program{IP: 0, Instructions: []Instruction{{"addi", 1, 1, 1}, {"seti", -1, 0, 0}}}
+
+	result, err := New(2).Execute(context.Background(), loop, 11)
+	if err != nil || result.Reason != BudgetExhausted || result.Instructions != 11 || result.IP != 1 {
+		t.Errorf("Execute with budget 11 = %+v, %v", result, err)
+	}
+	if result.Registers[1] != 6 {
+		t.Errorf("Execute with budget 11 left r1 = %d, expected 6", result.Registers[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = New(2).Execute(ctx, loop, math.MaxInt32)
+	if err != nil || result.Reason != Cancelled || result.Err != context.Canceled {
+		t.Errorf("Execute with cancelled context = %+v, %v", result, err)
+	}
+
+	jumpOut := &Program{IP: 0, Instructions: []Instruction{{"seti", -5, 0, 0}}}
+	result, err = New(1).Execute(context.Background(), jumpOut, math.MaxInt32)
+	if err != nil || result.Reason != Halted || result.IP != -4 || result.Instructions != 1 {
+		t.Errorf("Execute of negative jump = %+v, %v", result, err)
+	}
+}
+
+func TestStepRunContinue(t *testing.T) {
+	program := &Program{IP: 0,
+		Instructions: []Instruction{
+			{"seti", 5, 0, 1},
+			{"seti", 6, 0, 2},
+			{"addi", 0, 1, 0},
+			{"addr", 1, 2, 3},
+			{"setr", 1, 0, 0},
+			{"seti", 8, 0, 4},
+			{"seti", 9, 0, 5},
+		},
+	}
+
+	testDevice := New(6)
+	if result := testDevice.Step(); result.Reason != Fault || result.Err != ErrNoProgram {
+		t.Errorf("Step without a program = %+v", result)
+	}
+	if err := testDevice.Load(program); err != nil {
+		t.Fatalf("Load returned error %v", err)
+	}
+
+	result := testDevice.Step()
+	if result.Reason != BudgetExhausted || testDevice.IP != 1 || testDevice.Executed != 1 || testDevice.Registers[1] != 5 {
+		t.Errorf("After Step, device = %+v, result = %+v", testDevice, result)
+	}
+	result = testDevice.Run(2)
+	if result.Reason != BudgetExhausted || testDevice.IP != 4 || testDevice.Executed != 3 {
+		t.Errorf("After Run(2), device = %+v, result = %+v", testDevice, result)
+	}
+	result = testDevice.Continue()
+	if result.Reason != Halted || result.IP != 7 || result.Instructions != 5 {
+		t.Errorf("After Continue, result = %+v", result)
+	}
+	if !equal(testDevice.Registers, []int{6, 5, 6, 0, 0, 9}) {
+		t.Errorf("Final registers %v not equal to expected", testDevice.Registers)
+	}
+	if result := testDevice.Step(); result.Reason != Halted || result.Instructions != 5 {
+		t.Errorf("Step after halting = %+v", result)
+	}
+}
+
+func TestStepUntilInstruction(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDevice := New(6)
+	if err := testDevice.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	for testDevice.IP != 28 {
+		if result := testDevice.Step(); result.Reason != BudgetExhausted {
+			t.Fatalf("Stopped before reaching instruction 28: %+v", result)
+		}
+	}
+	if testDevice.Registers[4] != 15823996 {
+		t.Errorf("r4 at instruction 28 = %d, expected 15823996", testDevice.Registers[4])
+	}
+}
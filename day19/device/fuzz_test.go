@@ -0,0 +1,163 @@
+package device
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fuzzBudget is the number of instructions each fuzzed run may execute.
+const fuzzBudget = 1000
+
+// fuzzOperations lists the built-in operations by name, which fixes the
+// encoding of programs for fuzzing whatever other opcodes are registered.
+func fuzzOperations() []string {
+	var operations []string
+	for name := range basicOpcodes {
+		operations = append(operations, name)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// decodeProgram turns any data into a valid program for six registers. The
+// first byte picks the IP register, and each following seven bytes an
+// instruction: an operation and three 16-bit operands, register operands
+// taken modulo the number of registers and others as signed values.
+func decodeProgram(data []byte) *Program {
+	program := &Program{}
+	if len(data) == 0 {
+		return program
+	}
+	program.IP = int(data[0]) % 6
+	operations := fuzzOperations()
+	for data = data[1:]; len(data) >= 7; data = data[7:] {
+		operation := operations[int(data[0])%len(operations)]
+		kinds := operandKinds(operation)
+		var operands [3]int
+		for i, kind := range [3]OperandKind{kinds[0], kinds[1], RegisterOperand} {
+			value := binary.BigEndian.Uint16(data[1+2*i:])
+			if kind == RegisterOperand {
+				operands[i] = int(value) % 6
+			} else {
+				operands[i] = int(int16(value))
+			}
+		}
+		program.Instructions = append(program.Instructions, Instruction{operation, operands[0], operands[1], operands[2]})
+	}
+	return program
+}
+
+// encodeProgram is the inverse of decodeProgram for programs whose operands
+// fit in 16 bits.
+func encodeProgram(program *Program) []byte {
+	index := make(map[string]byte)
+	for i, name := range fuzzOperations() {
+		index[name] = byte(i)
+	}
+	data := []byte{byte(program.IP)}
+	for _, instruction := range program.Instructions {
+		data = append(data, index[instruction.operation])
+		for _, operand := range []int{instruction.a, instruction.b, instruction.c} {
+			data = binary.BigEndian.AppendUint16(data, uint16(int16(operand)))
+		}
+	}
+	return data
+}
+
+// FuzzCompiled runs random programs interpreted, compiled with idioms and
+// compiled one instruction at a time, which must all stop in the same state.
+func FuzzCompiled(f *testing.F) {
+	for _, filename := range []string{"../input.txt", "testdata/computed.txt"} {
+		program, err := ParseFile(filename)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encodeProgram(program), 0, 0)
+		f.Add(encodeProgram(program), 1, 0)
+		f.Add(encodeProgram(program), -5, 3)
+	}
+	for _, listing := range []string{
+		"#ip 3\nseti 6 0 5\n" + divisorSumLoop,
+		"#ip 1\nseti 300 0 3\n" + divisionLoop,
+	} {
+		program, err := ParseReader(strings.NewReader(listing))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encodeProgram(program), 0, 0)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, r0, r1 int) {
+		program := decodeProgram(data)
+		var results [3]Result
+		for mode := range results {
+			d := New(6)
+			d.Registers[0], d.Registers[1] = r0, r1
+			d.Interpret = mode == 1
+			if err := d.Load(program); err != nil {
+				t.Fatalf("decoded program %v is invalid: %v", program.Instructions, err)
+			}
+			if mode < 2 {
+				results[mode] = d.RunContext(context.Background(), fuzzBudget)
+				continue
+			}
+			for i := 0; i < fuzzBudget; i++ {
+				if results[mode] = d.Step(); results[mode].Reason != BudgetExhausted {
+					break
+				}
+			}
+		}
+		for mode, name := range []string{"interpreted", "stepped"} {
+			if !reflect.DeepEqual(results[mode+1], results[0]) {
+				t.Errorf("%v from r0 = %d, r1 = %d: %s %+v, compiled %+v", program.Instructions, r0, r1, name, results[mode+1], results[0])
+			}
+		}
+	})
+}
+
+// randomPrograms returns programs decoded from count deterministic byte
+// strings of instructions instructions each.
+func randomPrograms(count, instructions int) []*Program {
+	programs := make([]*Program, count)
+	state := uint64(1)
+	for i := range programs {
+		data := make([]byte, 1+7*instructions)
+		for j := range data {
+			state = state*6364136223846793005 + 1442695040888963407
+			data[j] = byte(state >> 56)
+		}
+		// Keep the a and b operands within a byte, so that jumps stay near
+		// the program.
+		for j := 2; j < len(data); j += 7 {
+			data[j] = byte(int8(data[j+1]) >> 7)
+			data[j+2] = byte(int8(data[j+3]) >> 7)
+		}
+		programs[i] = decodeProgram(data)
+	}
+	return programs
+}
+
+// FuzzOptimize runs random programs before and after Optimize, which must
+// halt with the same registers if either halts.
+func FuzzOptimize(f *testing.F) {
+	for _, listing := range []string{
+		"#ip 1\nbanr 2 3 1\naddr 1 0 0\naddi 0 2 0\n",
+		"#ip 2\nsetr 0 0 0\nsetr 1 0 1\n",
+		"#ip 3\nseti 5 0 1\ngtri 0 3 1\naddr 1 3 3\nseti 4 0 3\naddi 0 10 0\nseti 99 0 3\n",
+	} {
+		program, err := ParseReader(strings.NewReader(listing))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encodeProgram(program), 0, 1)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, r0, r1 int) {
+		program := decodeProgram(data)
+		if !differentialOptimize(t, program, []int{r0, r1, 1, 1, 0, 0}) {
+			t.Errorf("#ip %d %v from r0 = %d, r1 = %d", program.IP, program.Instructions, r0, r1)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+var ErrRegister0Used = errors.New("register 0 used other than in an equality test")
+
+// HaltingInput is an initial value of register 0 that makes a program halt.
+type HaltingInput struct {
+	R0           int
+	Instructions int // executed before halting
+}
+
+// HaltingAnalysis is the result of AnalyzeHaltingInputs.
+type HaltingAnalysis struct {
+	// Inputs holds the values of register 0 that make the program halt, in
+	// the order the run reached the comparisons against them.
+	Inputs []HaltingInput
+	// Stop tells how the run in which no comparison with register 0 holds
+	// ended. Halted means the program also halts for any value of register
+	// 0 it never compared against. CycleDetected means the comparisons
+	// have all been seen. BudgetExhausted means there may be more inputs.
+	Stop         HaltReason
+	Instructions int // executed by that run
+}
+
+// Fewest returns the input that halts the program in the fewest
+// instructions.
+func (a *HaltingAnalysis) Fewest() (HaltingInput, bool) {
+	return a.pick(func(i, best HaltingInput) bool { return i.Instructions < best.Instructions })
+}
+
+// Most returns the input that halts the program in the most instructions.
+func (a *HaltingAnalysis) Most() (HaltingInput, bool) {
+	return a.pick(func(i, best HaltingInput) bool { return i.Instructions > best.Instructions })
+}
+
+func (a *HaltingAnalysis) pick(better func(i, best HaltingInput) bool) (HaltingInput, bool) {
+	if len(a.Inputs) == 0 {
+		return HaltingInput{}, false
+	}
+	best := a.Inputs[0]
+	for _, input := range a.Inputs[1:] {
+		if better(input, best) {
+			best = input
+		}
+	}
+	return best, true
+}
+
+// r0Test is an equality test against register 0, with the other operand.
+type r0Test struct {
+	register bool // whether operand names a register
+	operand  int
+}
+
+// AnalyzeHaltingInputs finds the initial values of register 0 that make
+// program halt within budget instructions, for a program that reads
+// register 0 only in equality tests and never writes it, as in day 21.
+// Other registers start with the values in registers.
+//
+// The program is run as if every test against register 0 failed. The
+// first time a test compares register 0 with a value, the run so far is
+// the same as with register 0 holding that value, so a copy of the device
+// continues from there with it to see whether it halts. The run stops when
+// it halts, returns to an earlier state or exhausts the budget.
+func AnalyzeHaltingInputs(ctx context.Context, program *Program, registers []int, budget int) (*HaltingAnalysis, error) {
+	d := New(len(registers))
+	copy(d.Registers, registers)
+	if err := d.Load(program); err != nil {
+		return nil, err
+	}
+	tests, err := program.r0Tests()
+	if err != nil {
+		return nil, err
+	}
+	// Idioms reading register 0 would see its value rather than fail the
+	// tests.
+	idioms := make([]*idiomMatch, len(program.Instructions))
+	for i, match := range d.idioms {
+		if match != nil && !match.uses(0) {
+			idioms[i] = match
+		}
+	}
+
+	analysis := &HaltingAnalysis{}
+	seen := make(map[int]bool)
+	r := d.Registers
+	ipRegister := program.IP
+	cycles := newCycleDetector(d.IP, r)
+	ip, executed := d.IP, 0
+	for nextCheck := 0; ; executed++ {
+		if executed > 0 && cycles.observe(ip, r, executed) {
+			analysis.Stop = CycleDetected
+			break
+		}
+		if ip < 0 || ip >= len(program.Instructions) {
+			analysis.Stop = Halted
+			break
+		}
+		if executed >= budget {
+			analysis.Stop = BudgetExhausted
+			break
+		}
+		if executed >= nextCheck {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			nextCheck = executed + cancelCheckInterval
+		}
+		if match := idioms[ip]; match != nil {
+			if count, ok := match.run(r, match.bindings, budget-executed); ok {
+				r[ipRegister] = match.exit - 1
+				ip = match.exit
+				executed += count - 1
+				continue
+			}
+		}
+		r[ipRegister] = ip
+		test, ok := tests[ip]
+		if !ok {
+			d.code[ip](r)
+			ip = r[ipRegister] + 1
+			continue
+		}
+		value := test.operand
+		if test.register {
+			value = r[value]
+		}
+		if !seen[value] {
+			seen[value] = true
+			fork := d.replica(ip, r)
+			fork.Registers[0] = value
+			fork.DetectCycles = true
+			result := fork.RunContext(ctx, budget-executed)
+			switch result.Reason {
+			case Cancelled:
+				return nil, result.Err
+			case Halted:
+				analysis.Inputs = append(analysis.Inputs, HaltingInput{value, executed + result.Instructions})
+			}
+		}
+		r[program.Instructions[ip].c] = 0
+		ip = r[ipRegister] + 1
+	}
+	analysis.Instructions = executed
+	return analysis, nil
+}
+
+// r0Tests returns the equality tests against register 0 by instruction,
+// failing if register 0 is used in any other way. The program must be
+// valid.
+func (p *Program) r0Tests() (map[int]r0Test, error) {
+	if p.IP == 0 {
+		return nil, fmt.Errorf("%w: it is the #ip register", ErrRegister0Used)
+	}
+	tests := make(map[int]r0Test)
+	for i, instruction := range p.Instructions {
+		newError := func(operand byte) error {
+			err := &InstructionError{Index: i, Instruction: instruction, Operand: operand, Err: ErrRegister0Used}
+			if i < len(p.Lines) {
+				err.Line = p.Lines[i]
+			}
+			return err
+		}
+		if instruction.c == 0 {
+			return nil, newError('c')
+		}
+		kinds := operandKinds(instruction.operation)
+		operands := [2]int{instruction.a, instruction.b}
+		reads := [2]bool{}
+		for j, kind := range kinds {
+			reads[j] = kind == RegisterOperand && operands[j] == 0
+		}
+		switch {
+		case !reads[0] && !reads[1]:
+			continue
+		case instruction.operation != "eqrr" && instruction.operation != "eqri" && instruction.operation != "eqir":
+			if reads[0] {
+				return nil, newError('a')
+			}
+			return nil, newError('b')
+		case reads[0] && reads[1]:
+			// r0 == r0 holds whatever register 0 holds.
+			continue
+		case reads[0]:
+			tests[i] = r0Test{kinds[1] == RegisterOperand, operands[1]}
+		default:
+			tests[i] = r0Test{kinds[0] == RegisterOperand, operands[0]}
+		}
+	}
+	return tests, nil
+}
+
+// uses reports whether the idiom reads or writes register.
+func (m *idiomMatch) uses(register int) bool {
+	for name, value := range m.bindings {
+		if unicode.IsUpper(rune(name[0])) && value == register {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeHaltingInputs(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(`#ip 5
+addi 2 1 2
+bani 2 3 2
+eqrr 0 2 3
+addr 3 5 5
+seti -1 0 5
+seti 99 0 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := AnalyzeHaltingInputs(context.Background(), program, make([]int, 6), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HaltingInput{{1, 5}, {2, 10}, {3, 15}, {0, 20}}
+	if !reflect.DeepEqual(analysis.Inputs, want) || analysis.Stop != CycleDetected {
+		t.Errorf("got %+v %v, want %+v %v", analysis.Inputs, analysis.Stop, want, CycleDetected)
+	}
+	if fewest, _ := analysis.Fewest(); fewest != (HaltingInput{1, 5}) {
+		t.Errorf("fewest: got %+v", fewest)
+	}
+	if most, _ := analysis.Most(); most != (HaltingInput{0, 20}) {
+		t.Errorf("most: got %+v", most)
+	}
+}
+
+func TestAnalyzeHaltingInputsDay21(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := AnalyzeHaltingInputs(context.Background(), program, make([]int, 6), math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Stop != CycleDetected {
+		t.Errorf("stopped: %v", analysis.Stop)
+	}
+	fewest, _ := analysis.Fewest()
+	most, _ := analysis.Most()
+	// The first value compared is the one that halts soonest.
+	if fewest != analysis.Inputs[0] {
+		t.Errorf("fewest %+v is not the first input %+v", fewest, analysis.Inputs[0])
+	}
+	for _, input := range []HaltingInput{fewest, most} {
+		d := New(6)
+		d.Registers[0] = input.R0
+		result, err := d.Execute(context.Background(), program, math.MaxInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Reason != Halted || result.Instructions != input.Instructions {
+			t.Errorf("r0 = %d: got %v after %d instructions, want halted after %d", input.R0, result.Reason, result.Instructions, input.Instructions)
+		}
+	}
+}
+
+func TestAnalyzeHaltingInputsRejects(t *testing.T) {
+	for _, listing := range []string{
+		"#ip 5\naddr 0 1 2\n",
+		"#ip 5\ngtrr 1 0 2\n",
+		"#ip 5\nseti 1 0 0\n",
+		"#ip 0\neqri 1 0 2\n",
+	} {
+		program, err := ParseReader(strings.NewReader(listing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AnalyzeHaltingInputs(context.Background(), program, make([]int, 6), 1000); !errors.Is(err, ErrRegister0Used) {
+			t.Errorf("%q: got %v", listing, err)
+		}
+	}
+}
+
+func TestAnalyzeHaltingInputsCancelled(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AnalyzeHaltingInputs(ctx, program, make([]int, 6), math.MaxInt); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+}
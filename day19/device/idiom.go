@@ -0,0 +1,259 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// idiom is a loop recognized by its instructions and run natively, leaving
+// the registers, instruction pointer and instruction count exactly as
+// executing it would.
+type idiom struct {
+	name string
+	// pattern holds the loop's instructions from its entry. It always exits
+	// to the instruction after the last one. Operands are register roles
+	// (upper case letters), IP for the IP register, immediates (lower case
+	// letters), offsets from the entry (@n), literals or _ for any value.
+	pattern []string
+	// run performs the loop on registers and returns the number of
+	// instructions it stands for. It leaves the registers untouched and
+	// returns false if that is more than budget or the native computation
+	// could differ from the instructions, as when a value would overflow.
+	run func(registers []int, v bindings, budget int) (int, bool)
+}
+
+// bindings maps the roles and immediates of a pattern to their values.
+type bindings map[string]int
+
+// idiomMatch is an idiom found in a program.
+type idiomMatch struct {
+	*idiom
+	entry, exit int
+	bindings    bindings
+}
+
+var idioms = []*idiom{
+	{
+		// S += sum of the divisors of N, by trying every A*B for A and B up to N.
+		name: "sum of divisors",
+		pattern: []string{
+			"seti 1 _ A",
+			"seti 1 _ B",
+			"mulr A B T",
+			"eqrr T N T",
+			"addr T IP IP",
+			"addi IP 1 IP",
+			"addr A S S",
+			"addi B 1 B",
+			"gtrr B N T",
+			"addr IP T IP",
+			"seti @1 _ IP",
+			"addi A 1 A",
+			"gtrr A N T",
+			"addr T IP IP",
+			"seti @0 _ IP",
+		},
+		run: func(r []int, v bindings, budget int) (int, bool) {
+			n := r[v["N"]]
+			m := n
+			if m < 1 {
+				m = 1
+			}
+			if m > 1<<29 {
+				return 0, false
+			}
+			count := 8*m*m + 4*m
+			if count > budget {
+				return 0, false
+			}
+			sum := 0
+			for i := 1; i*i <= n; i++ {
+				if n%i == 0 {
+					sum += i
+					if i != n/i {
+						sum += n / i
+					}
+				}
+			}
+			r[v["S"]] += sum
+			r[v["A"]] = m + 1
+			r[v["B"]] = m + 1
+			r[v["T"]] = 1
+			return count, true
+		},
+	},
+	{
+		// Q = D / k, by counting up until (Q+1)*k exceeds D.
+		name: "division",
+		pattern: []string{
+			"seti 0 _ Q",
+			"addi Q 1 T",
+			"muli T k T",
+			"gtrr T D T",
+			"addr T IP IP",
+			"addi IP 1 IP",
+			"seti @8 _ IP",
+			"addi Q 1 Q",
+			"seti @0 _ IP",
+		},
+		run: func(r []int, v bindings, budget int) (int, bool) {
+			d, k := r[v["D"]], v["k"]
+			if k <= 0 || d > math.MaxInt-k {
+				return 0, false
+			}
+			q := 0
+			if d >= 0 {
+				q = d / k
+			}
+			if q > (math.MaxInt-6)/7 {
+				return 0, false
+			}
+			count := 1 + 7*q + 5
+			if count > budget {
+				return 0, false
+			}
+			r[v["Q"]] = q
+			r[v["T"]] = 1
+			return count, true
+		},
+	},
+}
+
+// commutative operations match a pattern with their operands either way
+// round.
+var commutative = map[string]bool{
+	"addr": true, "mulr": true, "banr": true, "borr": true, "eqrr": true,
+}
+
+// findIdioms returns the idiom entered at each instruction, or nil if the
+// program has none. Reads of the IP register are inlined in both the
+// program and the patterns before matching, so loops match whether they
+// are written relative to the IP register or not.
+func (p *Program) findIdioms() []*idiomMatch {
+	p = inlineIPReads(p)
+	var found []*idiomMatch
+	for _, idiom := range idioms {
+		pattern := make([]string, len(idiom.pattern))
+		for k, line := range idiom.pattern {
+			pattern[k] = inlinePattern(line, k)
+		}
+		for entry := 0; entry+len(pattern) <= len(p.Instructions); entry++ {
+			v, ok := p.match(pattern, entry, 0, bindings{})
+			if !ok {
+				continue
+			}
+			if found == nil {
+				found = make([]*idiomMatch, len(p.Instructions))
+			}
+			if found[entry] == nil {
+				found[entry] = &idiomMatch{idiom, entry, entry + len(idiom.pattern), v}
+			}
+		}
+	}
+	return found
+}
+
+// inlinePattern rewrites line k of a pattern as inlineIP rewrites the
+// instruction it matches, reading the offset @k instead of the IP register.
+// Reads that leave no register operand must add a literal to the IP.
+func inlinePattern(line string, k int) string {
+	fields := strings.Fields(line)
+	opcode := basicOpcodes[fields[0]]
+	kinds := [2]OperandKind{opcode.a, opcode.b}
+	inlined := false
+	for j, kind := range kinds {
+		if kind == RegisterOperand && fields[1+j] == "IP" {
+			kinds[j], fields[1+j] = ImmediateOperand, "@"+strconv.Itoa(k)
+			inlined = true
+		}
+	}
+	if !inlined {
+		return line
+	}
+	if kinds[0] != RegisterOperand && kinds[1] != RegisterOperand {
+		literal, err := strconv.Atoi(fields[2])
+		if fields[0] != "addi" || err != nil {
+			panic(fmt.Sprintf("cannot inline IP reads in pattern %q", line))
+		}
+		return fmt.Sprintf("seti @%d _ %s", k+literal, fields[3])
+	}
+	name, swapped, ok := withOperandKinds(fields[0], kinds)
+	if !ok {
+		panic(fmt.Sprintf("cannot inline IP reads in pattern %q", line))
+	}
+	if swapped {
+		fields[1], fields[2] = fields[2], fields[1]
+	}
+	fields[0] = name
+	return strings.Join(fields, " ")
+}
+
+// match matches pattern[k:] against the instructions from entry+k.
+func (p *Program) match(pattern []string, entry, k int, v bindings) (bindings, bool) {
+	if k == len(pattern) {
+		return v, true
+	}
+	fields := strings.Fields(pattern[k])
+	instruction := p.Instructions[entry+k]
+	if instruction.operation != fields[0] {
+		return nil, false
+	}
+	orders := [][2]int{{instruction.a, instruction.b}}
+	if commutative[fields[0]] {
+		orders = append(orders, [2]int{instruction.b, instruction.a})
+	}
+	for _, order := range orders {
+		bound := make(bindings, len(v))
+		for name, value := range v {
+			bound[name] = value
+		}
+		operands := [3]int{order[0], order[1], instruction.c}
+		ok := true
+		for i, token := range fields[1:] {
+			if ok = p.bind(bound, token, operands[i], entry); !ok {
+				break
+			}
+		}
+		if ok {
+			if result, ok := p.match(pattern, entry, k+1, bound); ok {
+				return result, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// bind matches a pattern operand against value, recording new roles in v.
+// Distinct register roles must be distinct registers other than IP.
+func (p *Program) bind(v bindings, token string, value, entry int) bool {
+	switch {
+	case token == "_":
+		return true
+	case token == "IP":
+		return value == p.IP
+	case token[0] == '@':
+		offset, _ := strconv.Atoi(token[1:])
+		return value == entry+offset
+	case unicode.IsDigit(rune(token[0])):
+		literal, _ := strconv.Atoi(token)
+		return value == literal
+	}
+	if bound, ok := v[token]; ok {
+		return bound == value
+	}
+	if unicode.IsUpper(rune(token[0])) {
+		if value == p.IP {
+			return false
+		}
+		for name, bound := range v {
+			if unicode.IsUpper(rune(name[0])) && bound == value {
+				return false
+			}
+		}
+	}
+	v[token] = value
+	return true
+}
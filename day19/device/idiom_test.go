@@ -0,0 +1,141 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const divisorSumLoop = `seti 1 0 4
+seti 1 7 2
+mulr 4 2 1
+eqrr 1 5 1
+addr 1 3 3
+addi 3 1 3
+addr 4 0 0
+addi 2 1 2
+gtrr 2 5 1
+addr 3 1 3
+seti 2 6 3
+addi 4 1 4
+gtrr 4 5 1
+addr 1 3 3
+seti 1 6 3
+`
+
+const divisionLoop = `seti 0 7 5
+addi 5 1 2
+muli 2 256 2
+gtrr 2 3 2
+addr 2 1 1
+addi 1 1 1
+seti 9 1 1
+addi 5 1 5
+seti 1 6 1
+`
+
+func TestFindIdioms(t *testing.T) {
+	var tests = []struct {
+		filename string
+		entry    int
+		name     string
+		bindings bindings
+	}{
+		{"../input.txt", 1, "sum of divisors", bindings{"A": 4, "B": 2, "T": 1, "N": 5, "S": 0}},
+		{"../../day21/input.txt", 17, "division", bindings{"Q": 5, "T": 2, "k": 256, "D": 3}},
+	}
+	for _, test := range tests {
+		program, err := ParseFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Loops match with reads of the IP register inlined too.
+		for _, p := range []*Program{program, inlineIPReads(program)} {
+			found := p.findIdioms()
+			for entry, match := range found {
+				if match == nil {
+					continue
+				}
+				if entry != test.entry || match.name != test.name || !reflect.DeepEqual(match.bindings, test.bindings) {
+					t.Errorf("%s: found %s at %d with %v, expected %s at %d with %v", test.filename,
+						match.name, entry, match.bindings, test.name, test.entry, test.bindings)
+				}
+			}
+			if found == nil || found[test.entry] == nil {
+				t.Errorf("%s: no idiom at %d", test.filename, test.entry)
+			}
+		}
+	}
+}
+
+// runBoth runs program from registers with and without idioms and fails the
+// test if the results differ.
+func runBoth(t *testing.T, name string, program *Program, registers []int, budget int) Result {
+	var results [2]Result
+	for i, interpret := range []bool{false, true} {
+		d := New(len(registers))
+		d.Interpret = interpret
+		copy(d.Registers, registers)
+		var err error
+		if results[i], err = d.Execute(context.Background(), program, budget); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("%s: with idioms %+v, interpreted %+v", name, results[0], results[1])
+	}
+	return results[0]
+}
+
+func TestIdiomsKeepState(t *testing.T) {
+	var tests = []struct {
+		listing string
+		values  []int
+	}{
+		{"#ip 3\nseti %d 0 5\n" + divisorSumLoop, []int{-3, 0, 1, 2, 12, 36, 97, 100}},
+		{"#ip 1\nseti %d 0 3\n" + divisionLoop, []int{-300, 0, 1, 255, 256, 257, 5000, 65535}},
+	}
+	for _, test := range tests {
+		for _, value := range test.values {
+			program, err := ParseReader(strings.NewReader(fmt.Sprintf(test.listing, value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if program.findIdioms() == nil {
+				t.Fatalf("No idiom found in %q", test.listing)
+			}
+			name := fmt.Sprintf("%q with %d", strings.SplitN(test.listing, "\n", 3)[1], value)
+			result := runBoth(t, name, program, []int{0, 0, 0, 0, 0, 0}, math.MaxInt)
+			// Stopping part way through a loop has to stop at the same place.
+			for _, budget := range []int{2, 7, result.Instructions / 2, result.Instructions - 1} {
+				runBoth(t, fmt.Sprintf("%s and budget %d", name, budget), program, []int{0, 0, 0, 0, 0, 0}, budget)
+			}
+		}
+	}
+}
+
+func TestDay19Part2(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(6)
+	d.Registers[0] = 1
+	result, err := d.Execute(context.Background(), program, math.MaxInt)
+	if err != nil || result.Reason != Halted {
+		t.Fatalf("Execute = %+v, %v", result, err)
+	}
+	n := result.Registers[5]
+	sum := 0
+	for i := 1; i <= n; i++ {
+		if n%i == 0 {
+			sum += i
+		}
+	}
+	if result.Registers[0] != sum {
+		t.Errorf("r0 = %d, expected the sum of the divisors of %d, %d", result.Registers[0], n, sum)
+	}
+}
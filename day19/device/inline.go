@@ -0,0 +1,65 @@
+package device
+
+// inlineIPReads returns p with reads of the IP register, which always holds
+// the index of the instruction reading it, replaced by immediates.
+func inlineIPReads(p *Program) *Program {
+	q := &Program{IP: p.IP, Instructions: make([]Instruction, len(p.Instructions)), Lines: p.Lines}
+	for i, instruction := range p.Instructions {
+		q.Instructions[i] = p.inlineIP(i, instruction)
+	}
+	return q
+}
+
+// inlineIP rewrites instruction i to use the immediate i instead of reading
+// the IP register, if a built-in opcode can express that.
+func (p *Program) inlineIP(i int, instruction Instruction) Instruction {
+	opcode, ok := basicOpcodes[instruction.operation]
+	if !ok {
+		return instruction
+	}
+	kinds := [2]OperandKind{opcode.a, opcode.b}
+	operands := [2]int{instruction.a, instruction.b}
+	inlined := false
+	for j, kind := range kinds {
+		if kind == RegisterOperand && operands[j] == p.IP {
+			kinds[j], operands[j] = ImmediateOperand, i
+			inlined = true
+		}
+	}
+	switch {
+	case !inlined:
+		return instruction
+	case kinds[0] != RegisterOperand && kinds[1] != RegisterOperand:
+		return Instruction{"seti", opcode.apply(operands[0], operands[1]), 0, instruction.c}
+	}
+	name, swapped, ok := withOperandKinds(instruction.operation, kinds)
+	switch {
+	case !ok:
+		return instruction
+	case swapped:
+		return Instruction{name, operands[1], operands[0], instruction.c}
+	}
+	return Instruction{name, operands[0], operands[1], instruction.c}
+}
+
+// withOperandKinds finds the built-in opcode that does what operation does
+// but with operands of the given kinds, or failing that, if operation is
+// commutative, with them swapped.
+func withOperandKinds(operation string, kinds [2]OperandKind) (name string, swapped, ok bool) {
+	opcode := basicOpcodes[operation]
+	for _, swap := range []bool{false, true} {
+		want := kinds
+		if swap {
+			if !commutative[operation] {
+				break
+			}
+			want = [2]OperandKind{kinds[1], kinds[0]}
+		}
+		for _, other := range basicOpcodes {
+			if other.operator == opcode.operator && other.a == want[0] && other.b == want[1] {
+				return other.name, swap, true
+			}
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,87 @@
+package device
+
+import "fmt"
+
+// Checks made by Lint.
+const (
+	CheckNeverWritten     = "never-written"
+	CheckDeadStore        = "dead-store"
+	CheckUnreachable      = "unreachable"
+	CheckJumpOutside      = "jump-outside"
+	CheckUnusedComparison = "unused-comparison"
+)
+
+// Finding is a problem Lint found with an instruction.
+type Finding struct {
+	Index   int    `json:"index"` // position in Program.Instructions
+	Line    int    `json:"line"`  // source line, 0 if unknown
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("line %d: %s (%s)", f.Line, f.Message, f.Check)
+	}
+	return fmt.Sprintf("instruction %d: %s (%s)", f.Index, f.Message, f.Check)
+}
+
+// Lint looks for instructions that are probably mistakes: the first read of
+// each register no instruction writes, so that it only ever holds its
+// initial value; writes overwritten before they are read; instructions no
+// run can reach; jumps beyond either end of the program; and comparisons
+// whose result is never read. Findings are ordered by instruction. The
+// program must be valid.
+func (p *Program) Lint() []Finding {
+	n := len(p.Instructions)
+	if n == 0 {
+		return nil
+	}
+	g := p.CFG()
+	in := p.propagate(make([]RegisterValue, p.registersUsed()))
+	liveOut := g.liveOut()
+	readLater := g.liveness(false)
+	written := make([]bool, p.registersUsed())
+	for _, instruction := range p.Instructions {
+		written[instruction.c] = true
+	}
+
+	var findings []Finding
+	for i, instruction := range p.Instructions {
+		report := func(check, format string, args ...interface{}) {
+			finding := Finding{Index: i, Check: check, Message: fmt.Sprintf(format, args...)}
+			if i < len(p.Lines) {
+				finding.Line = p.Lines[i]
+			}
+			findings = append(findings, finding)
+		}
+		if in[i] == nil {
+			report(CheckUnreachable, "%v is never reached", instruction)
+			continue
+		}
+		kinds := operandKinds(instruction.operation)
+		for j, operand := range [2]int{instruction.a, instruction.b} {
+			if kinds[j] == RegisterOperand && operand != p.IP && !written[operand] {
+				report(CheckNeverWritten, "%v reads r%d, which is never written", instruction, operand)
+				written[operand] = true // reported at its first read only
+			}
+		}
+		switch c := instruction.c; {
+		case c == p.IP:
+		case isComparison(instruction.operation):
+			if !readLater[i][c] {
+				report(CheckUnusedComparison, "%v sets r%d, which is never read", instruction, c)
+			}
+		case !liveOut[i][c]:
+			report(CheckDeadStore, "%v sets r%d, which is overwritten before it is read", instruction, c)
+		}
+		if jump := g.Jumps[i]; jump.Kind == ConstantJump || jump.Kind == ComputedJump {
+			for _, target := range jump.Targets {
+				if target < 0 || target > n {
+					report(CheckJumpOutside, "%v jumps to %d, outside the program", instruction, target)
+				}
+			}
+		}
+	}
+	return findings
+}
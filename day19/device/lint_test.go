@@ -0,0 +1,77 @@
+package device
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	type finding struct {
+		index, line int
+		check       string
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   []finding
+	}{
+		{"synthetic", `#ip 4
+seti 5 0 1
+addr 0 2 1
+eqri 1 3 3
+mulr 1 1 0
+seti 9 0 3
+seti 9 0 4
+addi 0 1 0
+`, []finding{
+			{0, 2, CheckDeadStore},
+			{1, 3, CheckNeverWritten},
+			{2, 4, CheckUnusedComparison},
+			{5, 7, CheckJumpOutside},
+			{6, 8, CheckUnreachable},
+		}},
+		{"clean", "#ip 2\ngtri 0 2 1\naddr 1 2 2\naddi 0 1 0\n", nil},
+	}
+	for _, test := range tests {
+		program, err := ParseReader(strings.NewReader(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []finding
+		for _, f := range program.Lint() {
+			got = append(got, finding{f.Index, f.Line, f.Check})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLintInputs(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     []string
+	}{
+		{"../input.txt", []string{
+			"line 18: mulr 3 3 3 jumps to 257, outside the program (jump-outside)",
+		}},
+		{"../../day21/input.txt", []string{
+			"line 6: seti 0 0 1 is never reached (unreachable)",
+			"line 30: eqrr 4 0 5 reads r0, which is never written (never-written)",
+		}},
+	}
+	for _, test := range tests {
+		program, err := ParseFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range program.Lint() {
+			got = append(got, f.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.filename, got, test.want)
+		}
+	}
+}
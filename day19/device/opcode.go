@@ -0,0 +1,167 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// OperandKind tells how an instruction uses an operand.
+type OperandKind int
+
+const (
+	IgnoredOperand   OperandKind = iota
+	RegisterOperand              // the operand names a register
+	ImmediateOperand             // the operand is a value
+)
+
+// Opcode is an operation of the instruction set. Opcodes are known to
+// ParseReader, Validate and Device once registered with RegisterOpcode.
+type Opcode interface {
+	// Name is the mnemonic used in program listings.
+	Name() string
+	// Operands gives the kinds of the a and b operands. The c operand
+	// always names the register that receives the result.
+	Operands() (a, b OperandKind)
+	// Execute performs the operation on registers. It must change no
+	// register other than c. Register operands are in range.
+	Execute(registers []int, a, b, c int)
+	// Go returns Go statements that perform the operation on an array of
+	// registers named r.
+	Go(a, b, c int) string
+}
+
+var ErrDuplicateOpcode = errors.New("opcode already registered")
+
+var registry = struct {
+	sync.RWMutex
+	opcodes map[string]Opcode
+}{opcodes: make(map[string]Opcode)}
+
+// RegisterOpcode adds opcode to the instruction set. Its name must be a
+// single word that is not yet registered.
+func RegisterOpcode(opcode Opcode) error {
+	name := opcode.Name()
+	if name == "" || strings.HasPrefix(name, "#") || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid opcode name %q", name)
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.opcodes[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateOpcode, name)
+	}
+	registry.opcodes[name] = opcode
+	return nil
+}
+
+// LookupOpcode returns the registered opcode called name.
+func LookupOpcode(name string) (Opcode, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	opcode, ok := registry.opcodes[name]
+	return opcode, ok
+}
+
+// Opcodes returns every registered opcode ordered by name.
+func Opcodes() []Opcode {
+	registry.RLock()
+	defer registry.RUnlock()
+	opcodes := make([]Opcode, 0, len(registry.opcodes))
+	for _, opcode := range registry.opcodes {
+		opcodes = append(opcodes, opcode)
+	}
+	sort.Slice(opcodes, func(i, j int) bool { return opcodes[i].Name() < opcodes[j].Name() })
+	return opcodes
+}
+
+// operandKinds returns the operand kinds of a registered operation.
+func operandKinds(operation string) [2]OperandKind {
+	opcode, _ := LookupOpcode(operation)
+	a, b := opcode.Operands()
+	return [2]OperandKind{a, b}
+}
+
+// basicOpcode is one of the sixteen built-in opcodes, whose result is a
+// function of its operand values.
+type basicOpcode struct {
+	name     string
+	a, b     OperandKind
+	operator string // Go operator, or "" for the set opcodes
+	apply    func(a, b int) int
+}
+
+func (o *basicOpcode) Name() string {
+	return o.name
+}
+
+func (o *basicOpcode) Operands() (OperandKind, OperandKind) {
+	return o.a, o.b
+}
+
+func (o *basicOpcode) Execute(registers []int, a, b, c int) {
+	if o.a == RegisterOperand {
+		a = registers[a]
+	}
+	if o.b == RegisterOperand {
+		b = registers[b]
+	}
+	registers[c] = o.apply(a, b)
+}
+
+func (o *basicOpcode) comparison() bool {
+	return o.operator == ">" || o.operator == "=="
+}
+
+func (o *basicOpcode) Go(a, b, c int) string {
+	operand := func(kind OperandKind, value int) string {
+		if kind == RegisterOperand {
+			return fmt.Sprintf("r[%d]", value)
+		}
+		return fmt.Sprint(value)
+	}
+	switch {
+	case o.operator == "":
+		return fmt.Sprintf("r[%d] = %s", c, operand(o.a, a))
+	case o.comparison():
+		return fmt.Sprintf("if %s %s %s {\n\tr[%d] = 1\n} else {\n\tr[%[4]d] = 0\n}", operand(o.a, a), o.operator, operand(o.b, b), c)
+	}
+	return fmt.Sprintf("r[%d] = %s %s %s", c, operand(o.a, a), o.operator, operand(o.b, b))
+}
+
+var basicOpcodes = map[string]*basicOpcode{}
+
+func init() {
+	add := func(a, b int) int { return a + b }
+	mul := func(a, b int) int { return a * b }
+	ban := func(a, b int) int { return a & b }
+	bor := func(a, b int) int { return a | b }
+	set := func(a, b int) int { return a }
+	gt := func(a, b int) int { return boolToInt(a > b) }
+	eq := func(a, b int) int { return boolToInt(a == b) }
+	for _, opcode := range []*basicOpcode{
+		{"addr", RegisterOperand, RegisterOperand, "+", add},
+		{"addi", RegisterOperand, ImmediateOperand, "+", add},
+		{"mulr", RegisterOperand, RegisterOperand, "*", mul},
+		{"muli", RegisterOperand, ImmediateOperand, "*", mul},
+		{"banr", RegisterOperand, RegisterOperand, "&", ban},
+		{"bani", RegisterOperand, ImmediateOperand, "&", ban},
+		{"borr", RegisterOperand, RegisterOperand, "|", bor},
+		{"bori", RegisterOperand, ImmediateOperand, "|", bor},
+		{"setr", RegisterOperand, IgnoredOperand, "", set},
+		{"seti", ImmediateOperand, IgnoredOperand, "", set},
+		{"gtir", ImmediateOperand, RegisterOperand, ">", gt},
+		{"gtri", RegisterOperand, ImmediateOperand, ">", gt},
+		{"gtrr", RegisterOperand, RegisterOperand, ">", gt},
+		{"eqir", ImmediateOperand, RegisterOperand, "==", eq},
+		{"eqri", RegisterOperand, ImmediateOperand, "==", eq},
+		{"eqrr", RegisterOperand, RegisterOperand, "==", eq},
+	} {
+		basicOpcodes[opcode.name] = opcode
+		if err := RegisterOpcode(opcode); err != nil {
+			panic(err)
+		}
+	}
+}
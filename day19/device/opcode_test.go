@@ -0,0 +1,76 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// divi divides a register by an immediate, rounding towards zero.
+type divi struct{}
+
+func (divi) Name() string                         { return "divi" }
+func (divi) Operands() (OperandKind, OperandKind) { return RegisterOperand, ImmediateOperand }
+func (divi) Execute(registers []int, a, b, c int) { registers[c] = registers[a] / b }
+func (divi) Go(a, b, c int) string                { return fmt.Sprintf("r[%d] = r[%d] / %d", c, a, b) }
+
+var registerDivi sync.Once
+
+func TestRegisterOpcode(t *testing.T) {
+	registerDivi.Do(func() {
+		if err := RegisterOpcode(divi{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := RegisterOpcode(divi{}); !errors.Is(err, ErrDuplicateOpcode) {
+		t.Errorf("Registering divi twice returned %v", err)
+	}
+	if err := RegisterOpcode(&basicOpcode{name: "#ip"}); err == nil {
+		t.Error("Registering an opcode called #ip succeeded")
+	}
+	if opcodes := Opcodes(); len(opcodes) < 17 || opcodes[0].Name() != "addi" {
+		t.Errorf("Opcodes() = %v", opcodes)
+	}
+
+	program, err := ParseReader(strings.NewReader("#ip 3\nseti 100 0 1\ndivi 1 7 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	built := NewProgram(3, NewInstruction("seti", 100, 0, 1), NewInstruction("divi", 1, 7, 2))
+	if built.IP != program.IP || len(built.Instructions) != 2 || built.Instructions[1] != program.Instructions[1] {
+		t.Errorf("NewProgram = %+v, parsed %+v", built, program)
+	}
+	for _, interpret := range []bool{false, true} {
+		d := New(4)
+		d.Interpret = interpret
+		result, err := d.Execute(context.Background(), built, math.MaxInt)
+		if err != nil || result.Reason != Halted || result.Registers[2] != 14 {
+			t.Errorf("Execute (interpret %v) = %+v, %v", interpret, result, err)
+		}
+	}
+
+	source, err := program.ToGo("elfcode", 4)
+	if err != nil || !strings.Contains(source, "r[2] = r[1] / 7") {
+		t.Errorf("ToGo = %q, %v", source, err)
+	}
+	if decompiled := program.Decompile(); !strings.Contains(decompiled, "r2 = divi(r1, 7)") {
+		t.Errorf("Decompile =\n%s", decompiled)
+	}
+	if err := NewProgram(0, NewInstruction("divi", 1, 2, 5)).Validate(4); !errors.Is(err, ErrRegisterOutOfRange) {
+		t.Errorf("Validate of divi into r5 = %v", err)
+	}
+}
+
+func TestInstructionAccessors(t *testing.T) {
+	instruction := NewInstruction("addr", 1, 2, 3)
+	if a, b, c := instruction.Operands(); instruction.Operation() != "addr" || a != 1 || b != 2 || c != 3 {
+		t.Errorf("NewInstruction(\"addr\", 1, 2, 3) = %v", instruction)
+	}
+	if err := NewProgram(0, NewInstruction("nope", 0, 0, 0)).Validate(4); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("Validate of an unknown opcode = %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package device
+
+// Pass rewrites a program into one that behaves the same: from any initial
+// registers it halts if and only if the original does, with the same
+// registers, though perhaps after a different number of instructions.
+// Passes take and return valid programs and keep the #ip binding. They
+// assume registers are Go ints: folded or merged arithmetic may give
+// different results on a device whose Width or OnOverflow options make it
+// wrap, saturate or trap elsewhere.
+type Pass struct {
+	Name  string
+	Apply func(p *Program) *Program
+}
+
+var (
+	// InlineIPReads replaces reads of the IP register, which always holds
+	// the index of the instruction reading it, with immediates.
+	InlineIPReads = Pass{"inline-ip", inlineIPReads}
+	// FoldConstants replaces instructions whose result is the same on every
+	// run with seti, and merges chains of addi on a register.
+	FoldConstants = Pass{"fold", foldConstants}
+	// ThreadJumps points jumps to a constant jump at its target instead.
+	ThreadJumps = Pass{"thread", threadJumps}
+	// RemoveUnreachable removes instructions no run can reach.
+	RemoveUnreachable = Pass{"unreachable", removeUnreachable}
+	// RemoveDeadStores removes instructions whose result is never read and
+	// those that change nothing.
+	RemoveDeadStores = Pass{"dead-stores", removeDeadStores}
+)
+
+// DefaultPasses returns the passes Optimize runs unless told otherwise, in
+// order.
+func DefaultPasses() []Pass {
+	return []Pass{InlineIPReads, FoldConstants, ThreadJumps, RemoveUnreachable, RemoveDeadStores}
+}
+
+// Optimize runs passes, or DefaultPasses if none are given, in turn until
+// they stop changing the program. The program must be valid.
+func (p *Program) Optimize(passes ...Pass) *Program {
+	if len(passes) == 0 {
+		passes = DefaultPasses()
+	}
+	for {
+		before := p
+		for _, pass := range passes {
+			p = pass.Apply(p)
+		}
+		if sameInstructions(before, p) {
+			return p
+		}
+	}
+}
+
+func sameInstructions(p, q *Program) bool {
+	if len(p.Instructions) != len(q.Instructions) {
+		return false
+	}
+	for i := range p.Instructions {
+		if p.Instructions[i] != q.Instructions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Program) copy() *Program {
+	return &Program{IP: p.IP, Instructions: append([]Instruction(nil), p.Instructions...), Lines: p.Lines}
+}
+
+func foldConstants(p *Program) *Program {
+	q := p.fold(p.propagate(make([]RegisterValue, p.registersUsed())))
+	q.mergeAddChains()
+	return q
+}
+
+// mergeAddChains folds each addi to a register into a later one to the same
+// register in the same block, if the register is not read in between. The
+// earlier one is left as an instruction that does nothing. Nothing is
+// merged if the program has an unresolved jump, as that may enter a block
+// anywhere.
+func (p *Program) mergeAddChains() {
+	g := p.CFG()
+	for _, jump := range g.Jumps {
+		if jump.Kind == UnresolvedJump {
+			return
+		}
+	}
+	for _, block := range g.Blocks {
+		last := make(map[int]int) // register to the addi that last changed it
+		for i := block.Start; i <= block.End; i++ {
+			instruction := p.Instructions[i]
+			if instruction.operation == "addi" && instruction.a == instruction.c && instruction.c != p.IP {
+				if j, ok := last[instruction.c]; ok {
+					p.Instructions[i].b += p.Instructions[j].b
+					p.Instructions[j] = Instruction{"setr", instruction.c, 0, instruction.c}
+				}
+				last[instruction.c] = i
+				continue
+			}
+			kinds := operandKinds(instruction.operation)
+			for k, operand := range [2]int{instruction.a, instruction.b} {
+				if kinds[k] == RegisterOperand {
+					delete(last, operand)
+				}
+			}
+			delete(last, instruction.c)
+		}
+	}
+}
+
+func threadJumps(p *Program) *Program {
+	q := p.copy()
+	n := len(p.Instructions)
+	for i, instruction := range p.Instructions {
+		if !p.isConstantJump(instruction) {
+			continue
+		}
+		target := instruction.a + 1
+		for seen := map[int]bool{i: true}; target >= 0 && target < n && !seen[target]; {
+			next := p.Instructions[target]
+			if !p.isConstantJump(next) {
+				break
+			}
+			seen[target] = true
+			target = next.a + 1
+		}
+		q.Instructions[i].a = target - 1
+	}
+	return q
+}
+
+func (p *Program) isConstantJump(instruction Instruction) bool {
+	return instruction.operation == "seti" && instruction.c == p.IP
+}
+
+func removeUnreachable(p *Program) *Program {
+	in := p.propagate(make([]RegisterValue, p.registersUsed()))
+	unreachable := make([]bool, len(p.Instructions))
+	for i, state := range in {
+		unreachable[i] = state == nil
+	}
+	return p.remove(unreachable)
+}
+
+func removeDeadStores(p *Program) *Program {
+	liveOut := p.CFG().liveOut()
+	dead := make([]bool, len(p.Instructions))
+	for i, instruction := range p.Instructions {
+		dead[i] = p.isNop(i, instruction) || instruction.c != p.IP && !liveOut[i][instruction.c]
+	}
+	return p.remove(dead)
+}
+
+// isNop reports whether instruction i leaves every register as it was and
+// carries on with the next instruction.
+func (p *Program) isNop(i int, instruction Instruction) bool {
+	a, b, c := instruction.a, instruction.b, instruction.c
+	switch instruction.operation {
+	case "setr":
+		return a == c
+	case "addi", "bori":
+		return a == c && b == 0
+	case "muli":
+		return a == c && b == 1
+	case "bani":
+		return a == c && b == -1
+	case "banr", "borr":
+		return a == c && b == c
+	case "seti":
+		return c == p.IP && a == i
+	}
+	return false
+}
+
+// remove deletes the marked instructions and moves jump targets to match.
+// Control passing to a deleted instruction passes to the next one kept. A
+// jump past the end that has to move with the instructions it may also
+// reach lands on a trampoline, an instruction appended to the program that
+// jumps on to the original target, so the IP register holds the same value
+// when the program halts. remove returns p unchanged if the program would
+// not get shorter or be left empty, or if some jump cannot be moved: an
+// unresolved one, one whose targets inside the program move by different
+// amounts, or one that would leave a different value in the IP register.
+func (p *Program) remove(marked []bool) *Program {
+	original := p
+	n := len(p.Instructions)
+	newIndex := make([]int, n+1)
+	kept := 0
+	for i := 0; i < n; i++ {
+		newIndex[i] = kept
+		if !marked[i] {
+			kept++
+		}
+	}
+	newIndex[n] = kept
+	if kept == n {
+		return p
+	}
+	if kept == 0 {
+		// An empty program halts without writing the IP register.
+		return p
+	}
+	// Reads of the IP register would see the new indices.
+	p = inlineIPReads(p)
+	g := p.CFG()
+	q := &Program{IP: p.IP}
+	trampolines := make(map[int]int) // target value of the IP register by index
+	for i, instruction := range p.Instructions {
+		if marked[i] {
+			continue
+		}
+		kinds := operandKinds(instruction.operation)
+		for k, operand := range [2]int{instruction.a, instruction.b} {
+			if kinds[k] == RegisterOperand && operand == p.IP {
+				return original
+			}
+		}
+		jump := g.Jumps[i]
+		switch {
+		case jump.Kind == Fallthrough:
+			// Running off the end leaves the last index in the IP register.
+			if newIndex[i+1] == kept && newIndex[i] != n-1 {
+				return original
+			}
+		case jump.Kind == UnresolvedJump:
+			return original
+		case p.isConstantJump(instruction):
+			if target := instruction.a + 1; target >= 0 && target < n {
+				if newIndex[target] == kept {
+					return original
+				}
+				instruction.a = newIndex[target] - 1
+			}
+		default:
+			shift, ok := commonShift(jump.Targets, newIndex, kept)
+			if !ok || shift != 0 && instruction.operation != "addi" {
+				return original
+			}
+			instruction.b += shift
+			for _, target := range jump.Targets {
+				if target < n || shift == 0 {
+					continue
+				}
+				if value, ok := trampolines[target+shift]; ok && value != target-1 {
+					return original
+				}
+				trampolines[target+shift] = target - 1
+			}
+		}
+		q.Instructions = append(q.Instructions, instruction)
+		if i < len(p.Lines) {
+			q.Lines = append(q.Lines, p.Lines[i])
+		}
+	}
+	if kept+len(trampolines) >= n {
+		return original
+	}
+	for k := kept; k < kept+len(trampolines); k++ {
+		value, ok := trampolines[k]
+		if !ok {
+			return original
+		}
+		q.Instructions = append(q.Instructions, Instruction{"seti", value, 0, p.IP})
+		if len(q.Lines) > 0 {
+			q.Lines = append(q.Lines, 0)
+		}
+	}
+	return q
+}
+
+// commonShift returns how far the targets inside the program move, if they
+// move alike and none moves to the end of the program, kept long. Targets
+// before the program halt and must stay where they are; those past the end
+// are left to trampolines.
+func commonShift(targets []int, newIndex []int, kept int) (int, bool) {
+	n := len(newIndex) - 1
+	shift, first, before := 0, true, false
+	for _, target := range targets {
+		switch {
+		case target < 0:
+			before = true
+			continue
+		case target >= n:
+			continue
+		case newIndex[target] == kept:
+			return 0, false
+		}
+		s := newIndex[target] - target
+		if !first && s != shift {
+			return 0, false
+		}
+		shift, first = s, false
+	}
+	return shift, !before || shift == 0
+}
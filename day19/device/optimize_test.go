@@ -0,0 +1,248 @@
+package device
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name   string
+		passes []Pass
+		source string
+		want   string
+	}{
+		{
+			"inline",
+			[]Pass{InlineIPReads},
+			"#ip 2\naddr 2 0 1\nmulr 2 2 0\nseti 99 0 2\n",
+			"#ip 2\naddi 0 0 1\nseti 1 0 0\nseti 99 0 2\n",
+		},
+		{
+			"fold",
+			[]Pass{FoldConstants},
+			"#ip 3\nseti 5 0 1\naddi 1 2 2\naddi 0 1 0\naddi 0 2 0\nseti 99 0 3\n",
+			"#ip 3\nseti 5 0 1\nseti 7 0 2\nsetr 0 0 0\naddi 0 3 0\nseti 99 0 3\n",
+		},
+		{
+			// The unresolved jump may land on the second addi.
+			"fold with unresolved jump",
+			[]Pass{FoldConstants},
+			"#ip 1\nbanr 2 3 1\naddr 1 0 0\naddi 0 2 0\n",
+			"#ip 1\nbanr 2 3 1\naddr 1 0 0\naddi 0 2 0\n",
+		},
+		{
+			"thread",
+			[]Pass{ThreadJumps},
+			"#ip 1\nseti 1 0 1\nseti 9 0 0\nseti 3 0 1\nseti 9 0 0\naddi 0 1 0\nseti 99 0 1\n",
+			"#ip 1\nseti 3 0 1\nseti 9 0 0\nseti 3 0 1\nseti 9 0 0\naddi 0 1 0\nseti 99 0 1\n",
+		},
+		{
+			"unreachable",
+			nil,
+			"#ip 1\nseti 1 0 1\nseti 9 0 0\nseti 3 0 1\nseti 9 0 0\naddi 0 1 0\nseti 99 0 1\n",
+			"#ip 1\naddi 0 1 0\nseti 99 0 1\n",
+		},
+		{
+			"dead store",
+			nil,
+			"#ip 3\nseti 5 0 1\ngtri 0 3 1\naddr 1 3 3\nseti 4 0 3\naddi 0 10 0\nseti 99 0 3\n",
+			"#ip 3\ngtri 0 3 1\naddi 1 1 3\nseti 99 0 3\naddi 0 10 0\nseti 99 0 3\n",
+		},
+		{
+			// Jumping past the end leaves 4 in the IP register; a trampoline
+			// keeps that when the jump moves.
+			"halting jump",
+			nil,
+			"#ip 2\nseti 5 0 1\nseti 6 0 1\ngtri 0 3 1\naddr 1 2 2\nseti 99 0 2\n",
+			"#ip 2\ngtri 0 3 1\naddi 1 1 2\nseti 99 0 2\nseti 4 0 2\n",
+		},
+		{
+			// Falling off the end leaves the index of the last instruction in
+			// the IP register, so it must not move.
+			"halting fallthrough",
+			nil,
+			"#ip 1\nseti 5 0 2\nseti 6 0 2\naddi 0 1 0\n",
+			"#ip 1\nseti 5 0 2\nseti 6 0 2\naddi 0 1 0\n",
+		},
+		{
+			// An empty program would leave the IP register as it was.
+			"all dead",
+			nil,
+			"#ip 2\nsetr 0 0 0\nsetr 1 0 1\n",
+			"#ip 2\nsetr 0 0 0\nsetr 1 0 1\n",
+		},
+		{
+			"unresolved jump",
+			nil,
+			"#ip 3\nseti 5 0 1\nseti 6 0 1\naddr 0 3 3\nseti 99 0 3\n",
+			"#ip 3\nseti 5 0 1\nseti 6 0 1\naddi 0 2 3\nseti 99 0 3\n",
+		},
+	}
+	for _, test := range tests {
+		program, err := ParseReader(strings.NewReader(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ParseReader(strings.NewReader(test.want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := program.Optimize(test.passes...)
+		if got.IP != want.IP || !reflect.DeepEqual(got.Instructions, want.Instructions) {
+			t.Errorf("%s: got %v, want %v", test.name, got.Instructions, want.Instructions)
+			continue
+		}
+		for r0 := -2; r0 < 6; r0++ {
+			original, optimized := optimizedRun(t, program, got, []int{r0, 0, 0, 0}, 100)
+			if optimized.Reason != original.Reason || !reflect.DeepEqual(optimized.Registers, original.Registers) {
+				t.Errorf("%s: r0 = %d: optimized program gave %+v, want %+v", test.name, r0, optimized, original)
+			}
+		}
+	}
+}
+
+// TestOptimizeRandomPrograms checks that optimized random programs halt with
+// the same registers as the originals.
+func TestOptimizeRandomPrograms(t *testing.T) {
+	var programs []*Program
+	for n := 1; n <= 10; n++ {
+		programs = append(programs, denseRandomPrograms(500, n)...)
+	}
+	state := uint64(1)
+	for _, program := range programs {
+		for run := 0; run < 8; run++ {
+			initial := make([]int, 4)
+			for r := range initial {
+				state = state*6364136223846793005 + 1442695040888963407
+				initial[r] = int(state>>61) - 2
+			}
+			if !differentialOptimize(t, program, initial) {
+				t.Errorf("#ip %d %v from %v", program.IP, program.Instructions, initial)
+			}
+		}
+	}
+}
+
+// differentialOptimize runs program and its optimized form from initial,
+// reporting whether they agree: if either halts within fuzzBudget
+// instructions, both must halt with the same registers. The optimized
+// program may take fewer instructions, so the original gets longer, or one
+// more to pass through a trampoline.
+func differentialOptimize(t *testing.T, program *Program, initial []int) bool {
+	t.Helper()
+	optimized := program.Optimize()
+	original, result := optimizedRun(t, program, optimized, initial, fuzzBudget)
+	switch {
+	case original.Reason != Halted && result.Reason == Halted:
+		original, _ = optimizedRun(t, program, optimized, initial, fuzzBudget*len(program.Instructions))
+	case original.Reason == Halted && result.Reason != Halted:
+		_, result = optimizedRun(t, program, optimized, initial, fuzzBudget+1)
+	}
+	if original.Reason != Halted && result.Reason != Halted {
+		return true
+	}
+	if result.Reason != original.Reason || !reflect.DeepEqual(result.Registers, original.Registers) {
+		t.Logf("optimized to %v: got %+v, want %+v", optimized.Instructions, result, original)
+		return false
+	}
+	return true
+}
+
+// denseRandomPrograms returns random programs using four registers and
+// immediates from -2 to 5, so that instructions often feed one another and
+// jump within the program.
+func denseRandomPrograms(count, instructions int) []*Program {
+	programs := randomPrograms(count, instructions)
+	for _, program := range programs {
+		program.IP %= 4
+		for i, instruction := range program.Instructions {
+			kinds := operandKinds(instruction.operation)
+			operands := [3]int{instruction.a, instruction.b, instruction.c}
+			for j, kind := range [3]OperandKind{kinds[0], kinds[1], RegisterOperand} {
+				if kind == RegisterOperand {
+					operands[j] %= 4
+				} else {
+					operands[j] = (operands[j]%8+8)%8 - 2
+				}
+			}
+			program.Instructions[i] = Instruction{instruction.operation, operands[0], operands[1], operands[2]}
+		}
+	}
+	return programs
+}
+
+// optimizedRun runs the original and optimized programs from initial.
+func optimizedRun(t *testing.T, original, optimized *Program, initial []int, budget int) (Result, Result) {
+	t.Helper()
+	var results [2]Result
+	for i, p := range []*Program{original, optimized} {
+		d := New(len(initial))
+		copy(d.Registers, initial)
+		var err error
+		if results[i], err = d.Execute(context.Background(), p, budget); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return results[0], results[1]
+}
+
+func TestOptimizeDay19(t *testing.T) {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r0   int
+		want int
+	}{
+		{0, 19},
+		{1, 20},
+	}
+	for _, test := range tests {
+		initial := []int{test.r0, 0, 0, 0, 0, 0}
+		c, err := program.PropagateConstants(initial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized := c.Fold().Optimize()
+		if optimized.IP != program.IP || len(optimized.Instructions) != test.want {
+			t.Errorf("r0 = %d: got %d instructions, want %d", test.r0, len(optimized.Instructions), test.want)
+		}
+		// The divisor sum idiom must still match for the run to finish.
+		original, result := optimizedRun(t, program, optimized, initial, math.MaxInt)
+		if result.Reason != Halted || !reflect.DeepEqual(result.Registers, original.Registers) {
+			t.Errorf("r0 = %d: optimized program gave %+v, want %+v", test.r0, result, original)
+		}
+	}
+}
+
+func TestOptimizeDay21(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized := program.Optimize()
+	if optimized.IP != program.IP || len(optimized.Instructions) >= len(program.Instructions) {
+		t.Errorf("got %d instructions, want fewer than %d: %v", len(optimized.Instructions), len(program.Instructions), optimized.Instructions)
+	}
+	analysis, err := AnalyzeHaltingInputs(context.Background(), program, make([]int, 6), 1000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Runs from other states do not halt; they must not halt optimized
+	// either.
+	states := [][]int{{0, 0, 0, 0, 0, 0}, {1, 2, 3, 4, 5, 6}}
+	for _, input := range analysis.Inputs[:3] {
+		states = append(states, []int{input.R0, 0, 0, 0, 0, 0})
+	}
+	for _, initial := range states {
+		original, result := optimizedRun(t, program, optimized, initial, 100000000)
+		if result.Reason != original.Reason || original.Reason == Halted && !reflect.DeepEqual(result.Registers, original.Registers) {
+			t.Errorf("%v: optimized program gave %+v, want %+v", initial, result, original)
+		}
+	}
+}
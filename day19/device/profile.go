@@ -0,0 +1,299 @@
+package device
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Profiler is a Tracer that counts how often each instruction runs and how
+// often control passes along each edge from one instruction to the next.
+//
+//	profiler := NewProfiler(program)
+//	d.Tracer = profiler
+type Profiler struct {
+	program *Program
+	// Counts holds the number of executions of each instruction.
+	Counts []int
+	// successors holds the outgoing edges of each instruction.
+	successors [][]EdgeCount
+	// Filename is reported as the source file in pprof output.
+	Filename string
+}
+
+// EdgeCount is the number of times control passed from one instruction to
+// another. To is outside the program for edges that halt it.
+type EdgeCount struct {
+	From, To int
+	Count    int
+}
+
+// BlockProfile summarizes a basic block, the instructions Start to End
+// inclusive, as observed at run time.
+type BlockProfile struct {
+	Start, End   int
+	Entries      int // times the block was entered
+	Instructions int // instructions executed inside the block
+}
+
+// LoopProfile summarizes a back edge from Tail to Head and the instructions
+// between them.
+type LoopProfile struct {
+	Head, Tail   int
+	Iterations   int // times the back edge was taken
+	Instructions int // instructions executed from Head to Tail
+}
+
+func NewProfiler(program *Program) *Profiler {
+	return &Profiler{
+		program:    program,
+		Counts:     make([]int, len(program.Instructions)),
+		successors: make([][]EdgeCount, len(program.Instructions)),
+	}
+}
+
+func (p *Profiler) Trace(record *TraceRecord) {
+	p.Counts[record.IP]++
+	next := record.After[p.program.IP] + 1
+	successors := p.successors[record.IP]
+	for i := range successors {
+		if successors[i].To == next {
+			successors[i].Count++
+			return
+		}
+	}
+	p.successors[record.IP] = append(successors, EdgeCount{record.IP, next, 1})
+}
+
+// Edges returns every observed edge ordered by source then target.
+func (p *Profiler) Edges() []EdgeCount {
+	var edges []EdgeCount
+	for _, successors := range p.successors {
+		edges = append(edges, successors...)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// Blocks splits the executed instructions into basic blocks using the
+// observed edges and returns them hottest first.
+func (p *Profiler) Blocks() []BlockProfile {
+	n := len(p.Counts)
+	leader := make([]bool, n+1)
+	leader[0] = true
+	for from, successors := range p.successors {
+		for _, edge := range successors {
+			if edge.To != from+1 || len(successors) > 1 {
+				leader[from+1] = true
+				if edge.To >= 0 && edge.To < n {
+					leader[edge.To] = true
+				}
+			}
+		}
+	}
+	var blocks []BlockProfile
+	for start := 0; start < n; {
+		end := start
+		for end+1 < n && !leader[end+1] {
+			end++
+		}
+		if p.Counts[start] > 0 {
+			block := BlockProfile{Start: start, End: end, Entries: p.Counts[start]}
+			for i := start; i <= end; i++ {
+				block.Instructions += p.Counts[i]
+			}
+			blocks = append(blocks, block)
+		}
+		start = end + 1
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Instructions > blocks[j].Instructions
+	})
+	return blocks
+}
+
+// Loops returns every taken back edge, most iterations first, so inner
+// loops come before the loops that contain them.
+func (p *Profiler) Loops() []LoopProfile {
+	var loops []LoopProfile
+	for _, edge := range p.Edges() {
+		if edge.To > edge.From || edge.To < 0 {
+			continue
+		}
+		loop := LoopProfile{Head: edge.To, Tail: edge.From, Iterations: edge.Count}
+		for i := edge.To; i <= edge.From; i++ {
+			loop.Instructions += p.Counts[i]
+		}
+		loops = append(loops, loop)
+	}
+	sort.SliceStable(loops, func(i, j int) bool {
+		if loops[i].Iterations != loops[j].Iterations {
+			return loops[i].Iterations > loops[j].Iterations
+		}
+		return loops[i].Instructions > loops[j].Instructions
+	})
+	return loops
+}
+
+// WriteReport writes the top hottest blocks and loops as text.
+func (p *Profiler) WriteReport(w io.Writer, top int) error {
+	total := 0
+	for _, count := range p.Counts {
+		total += count
+	}
+	if total == 0 {
+		total = 1
+	}
+	if _, err := fmt.Fprintf(w, "Hot blocks:\n"); err != nil {
+		return err
+	}
+	for i, block := range p.Blocks() {
+		if i == top {
+			break
+		}
+		if _, err := fmt.Fprintf(w, "  ip %3d-%-3d %14d instructions %6.2f%% %12d entries\n", block.Start, block.End,
+			block.Instructions, 100*float64(block.Instructions)/float64(total), block.Entries); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "Hot loops:\n"); err != nil {
+		return err
+	}
+	for i, loop := range p.Loops() {
+		if i == top {
+			break
+		}
+		if _, err := fmt.Fprintf(w, "  ip %3d-%-3d %14d instructions %6.2f%% %12d iterations\n", loop.Head, loop.Tail,
+			loop.Instructions, 100*float64(loop.Instructions)/float64(total), loop.Iterations); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteProfile writes the instruction counts as a gzipped pprof
+// profile.proto, readable with "go tool pprof". Each instruction is a
+// location whose function is its basic block, so "top" lists hot blocks and
+// "-lines" lists hot instructions.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	var profile protoBuffer
+	index := map[string]int{}
+	var table []string
+	str := func(s string) int {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = len(table)
+		table = append(table, s)
+		return len(table) - 1
+	}
+	str("")
+
+	valueType := func(typ, unit string) []byte {
+		var b protoBuffer
+		b.varintField(1, uint64(str(typ)))
+		b.varintField(2, uint64(str(unit)))
+		return b
+	}
+	profile.bytesField(1, valueType("instructions", "count"))
+
+	blockOf := make([]int, len(p.Counts))
+	blocks := p.Blocks()
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start < blocks[j].Start })
+	for i, block := range blocks {
+		for ip := block.Start; ip <= block.End; ip++ {
+			blockOf[ip] = i + 1
+		}
+	}
+
+	for ip, count := range p.Counts {
+		if count == 0 {
+			continue
+		}
+		var sample protoBuffer
+		sample.packedField(1, []uint64{uint64(ip + 1)})
+		sample.packedField(2, []uint64{uint64(count)})
+		profile.bytesField(2, sample)
+	}
+	for ip, count := range p.Counts {
+		if count == 0 {
+			continue
+		}
+		var line protoBuffer
+		line.varintField(1, uint64(blockOf[ip]))
+		line.varintField(2, uint64(p.line(ip)))
+		var location protoBuffer
+		location.varintField(1, uint64(ip+1))
+		location.varintField(3, uint64(ip))
+		location.bytesField(4, line)
+		profile.bytesField(4, location)
+	}
+	for i, block := range blocks {
+		name := fmt.Sprintf("block ip %d-%d", block.Start, block.End)
+		var function protoBuffer
+		function.varintField(1, uint64(i+1))
+		function.varintField(2, uint64(str(name)))
+		function.varintField(3, uint64(str(name)))
+		function.varintField(4, uint64(str(p.Filename)))
+		function.varintField(5, uint64(p.line(block.Start)))
+		profile.bytesField(5, function)
+	}
+	periodType := valueType("instructions", "count")
+	for _, s := range table {
+		profile.bytesField(6, []byte(s))
+	}
+	profile.bytesField(11, periodType)
+	profile.varintField(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// line returns the source line of instruction ip, falling back to ip+1 for
+// programs that were not parsed from a listing.
+func (p *Profiler) line(ip int) int {
+	if ip < len(p.program.Lines) {
+		return p.program.Lines[ip]
+	}
+	return ip + 1
+}
+
+// protoBuffer is just enough of the protocol buffer wire format to write a
+// pprof profile.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protoBuffer) varintField(field int, v uint64) {
+	b.varint(uint64(field)<<3 | 0)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bytesField(field int, v []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) packedField(field int, vs []uint64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	b.bytesField(field, packed)
+}
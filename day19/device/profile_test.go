@@ -0,0 +1,120 @@
+package device
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func profileDay19(t *testing.T, budget int) *Profiler {
+	program, err := ParseFile("../input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiler := NewProfiler(program)
+	testDevice := New(6)
+	testDevice.Tracer = profiler
+	if _, err := testDevice.Execute(context.Background(), program, budget); err != nil {
+		t.Fatal(err)
+	}
+	return profiler
+}
+
+func TestProfiler(t *testing.T) {
+	profiler := profileDay19(t, 100000)
+
+	total := 0
+	for _, count := range profiler.Counts {
+		total += count
+	}
+	if total != 100000 {
+		t.Errorf("Profiler counted %d instructions, expected 100000", total)
+	}
+
+	// The divisor search's inner loop is instructions 3 to 11.
+	blocks := profiler.Blocks()
+	if blocks[0].Start != 3 || blocks[0].End != 5 {
+		t.Errorf("Hottest block is %+v, expected ip 3-5", blocks[0])
+	}
+	loops := profiler.Loops()
+	if loops[0].Head != 3 || loops[0].Tail != 11 {
+		t.Errorf("Hottest loop is %+v, expected ip 3-11", loops[0])
+	}
+	if loops[1].Head != 2 || loops[1].Tail != 15 {
+		t.Errorf("Second hottest loop is %+v, expected ip 2-15", loops[1])
+	}
+
+	edges := profiler.Edges()
+	if edges[0] != (EdgeCount{0, 17, 1}) {
+		t.Errorf("First edge is %+v, expected the jump from 0 to 17", edges[0])
+	}
+
+	var report strings.Builder
+	if err := profiler.WriteReport(&report, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "ip   3-11 ") {
+		t.Errorf("Report does not mention the inner loop:\n%s", report.String())
+	}
+}
+
+func TestWriteProfile(t *testing.T) {
+	profiler := profileDay19(t, 10000)
+	profiler.Filename = "day19/input.txt"
+
+	var buffer bytes.Buffer
+	if err := profiler.WriteProfile(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walk the top-level fields of the Profile message.
+	fields := map[int]int{}
+	var table []string
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		data = data[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0:
+			_, n = readVarint(data)
+			data = data[n:]
+		case 2:
+			length, n := readVarint(data)
+			if field == 6 {
+				table = append(table, string(data[n:n+int(length)]))
+			}
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("Unexpected wire type %d for field %d", wireType, field)
+		}
+		fields[field]++
+	}
+	if fields[1] != 1 || fields[2] == 0 || fields[2] != fields[4] || fields[5] == 0 || fields[11] != 1 || fields[12] != 1 {
+		t.Errorf("Profile has fields %v", fields)
+	}
+	if len(table) == 0 || table[0] != "" || !strings.Contains(strings.Join(table, "\n"), "block ip 3-5") {
+		t.Errorf("String table = %q", table)
+	}
+}
+
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i, b := range data {
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v, len(data)
+}
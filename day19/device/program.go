@@ -3,10 +3,12 @@ package device
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 type Instruction struct {
@@ -14,82 +16,174 @@ type Instruction struct {
 	a, b, c   int
 }
 
+// NewInstruction returns an instruction applying the opcode called operation
+// to operands a, b and c. Validate reports operations that are not
+// registered.
+func NewInstruction(operation string, a, b, c int) Instruction {
+	return Instruction{operation, a, b, c}
+}
+
+// Operation returns the name of the instruction's opcode.
+func (i Instruction) Operation() string {
+	return i.operation
+}
+
+// Operands returns the instruction's operands.
+func (i Instruction) Operands() (a, b, c int) {
+	return i.a, i.b, i.c
+}
+
 type Program struct {
 	IP           int
 	Instructions []Instruction
+	// Lines holds the source line number of each instruction when the
+	// program was parsed from a listing.
+	Lines []int
+}
+
+// ParseError reports a malformed line in a program listing. Line and Column
+// are 1-based; a zero Line means the problem concerns the listing as a whole.
+type ParseError struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// NewProgram returns a program with the IP register bound to register ip.
+func NewProgram(ip int, instructions ...Instruction) *Program {
+	return &Program{IP: ip, Instructions: instructions}
 }
 
 func Parse(filename string) *Program {
-	file, err := os.Open(filename)
+	program, err := ParseFile(filename)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return program
+}
+
+func ParseFile(filename string) (*Program, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	program, err := ParseReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return program, nil
+}
+
+func ParseReader(r io.Reader) (*Program, error) {
+	scanner := bufio.NewScanner(r)
 
 	var program Program
+	ipLine := 0
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := splitFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0].text, "#") {
+			if fields[0].text != "#ip" {
+				return nil, &ParseError{lineNumber, fields[0].column, fmt.Sprintf("unknown directive %q", fields[0].text)}
+			}
+			if ipLine != 0 {
+				return nil, &ParseError{lineNumber, fields[0].column, fmt.Sprintf("duplicate #ip directive, first given on line %d", ipLine)}
+			}
+			values, err := parseOperands(lineNumber, fields, 1)
+			if err != nil {
+				return nil, err
+			}
+			program.IP = values[0]
+			ipLine = lineNumber
+			continue
+		}
+		if _, ok := LookupOpcode(fields[0].text); !ok {
+			return nil, &ParseError{lineNumber, fields[0].column, fmt.Sprintf("unknown opcode %q", fields[0].text)}
+		}
+		values, err := parseOperands(lineNumber, fields, 3)
+		if err != nil {
+			return nil, err
+		}
+		program.Instructions = append(program.Instructions, Instruction{fields[0].text, values[0], values[1], values[2]})
+		program.Lines = append(program.Lines, lineNumber)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if ipLine == 0 {
+		return nil, &ParseError{Msg: "missing #ip directive"}
+	}
+	return &program, nil
+}
 
-	scanner.Scan()
-	ipLine := scanner.Text()
-	program.IP, _ = strconv.Atoi(ipLine[4:])
+type field struct {
+	text   string
+	column int
+}
 
-	for scanner.Scan() {
-		instructionLine := scanner.Text()
-		parts := strings.Split(instructionLine, " ")
-		a, _ := strconv.Atoi(parts[1])
-		b, _ := strconv.Atoi(parts[2])
-		c, _ := strconv.Atoi(parts[3])
-		program.Instructions = append(program.Instructions, Instruction{parts[0], a, b, c})
+func splitFields(line string) []field {
+	var fields []field
+	start := -1
+	for i, r := range line {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				fields = append(fields, field{line[start:i], start + 1})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
 	}
-	return &program
+	if start >= 0 {
+		fields = append(fields, field{line[start:], start + 1})
+	}
+	return fields
 }
 
-func (p Program) ToGo() string {
-	var sb strings.Builder
-	sb.WriteString("r := make([]int, 6)\n")
-	for i, instruction := range p.Instructions {
-		sb.WriteString(fmt.Sprintf("// I%d:\n", i))
-		sb.WriteString(instruction.toGo())
-		sb.WriteByte('\n')
+func parseOperands(lineNumber int, fields []field, expected int) ([]int, error) {
+	operands := fields[1:]
+	if len(operands) != expected {
+		column := fields[len(fields)-1].column + len(fields[len(fields)-1].text)
+		if len(operands) > expected {
+			column = operands[expected].column
+		}
+		return nil, &ParseError{lineNumber, column,
+			fmt.Sprintf("%s takes %d operand(s), got %d", fields[0].text, expected, len(operands))}
 	}
-	return sb.String()
-}
-
-func (i Instruction) toGo() string {
-	switch i.operation {
-	case "addr":
-		return fmt.Sprintf("r[%d] = r[%d] + r[%d]", i.c, i.a, i.b)
-	case "addi":
-		return fmt.Sprintf("r[%d] = r[%d] + %d", i.c, i.a, i.b)
-	case "mulr":
-		return fmt.Sprintf("r[%d] = r[%d] * r[%d]", i.c, i.a, i.b)
-	case "muli":
-		return fmt.Sprintf("r[%d] = r[%d] * %d", i.c, i.a, i.b)
-	case "banr":
-		return fmt.Sprintf("r[%d] = r[%d] & r[%d]", i.c, i.a, i.b)
-	case "bani":
-		return fmt.Sprintf("r[%d] = r[%d] & %d", i.c, i.a, i.b)
-	case "borr":
-		return fmt.Sprintf("r[%d] = r[%d] | r[%d]", i.c, i.a, i.b)
-	case "bori":
-		return fmt.Sprintf("r[%d] = r[%d] | %d", i.c, i.a, i.b)
-	case "setr":
-		return fmt.Sprintf("r[%d] = r[%d]", i.c, i.a)
-	case "seti":
-		return fmt.Sprintf("r[%d] = %d", i.c, i.a)
-	case "gtir":
-		return fmt.Sprintf("if %d > r[%d] {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
-	case "gtri":
-		return fmt.Sprintf("if r[%d] > %d {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
-	case "gtrr":
-		return fmt.Sprintf("if r[%d] > r[%d] {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
-	case "eqir":
-		return fmt.Sprintf("if %d == r[%d] {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
-	case "eqri":
-		return fmt.Sprintf("if r[%d] == %d {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
-	case "eqrr":
-		return fmt.Sprintf("if r[%d] == r[%d] {\n\tr[%d]=1\n} else {\n\tr[%[3]d]=0\n}", i.a, i.b, i.c)
+	values := make([]int, expected)
+	for i, operand := range operands {
+		value, err := strconv.Atoi(operand.text)
+		if err != nil {
+			return nil, &ParseError{lineNumber, operand.column, fmt.Sprintf("operand %q is not a number", operand.text)}
+		}
+		values[i] = value
 	}
-	panic(fmt.Errorf("unknown operation %v", i.operation))
+	return values, nil
+}
+
+// InstructionAt returns the index of the first instruction on or after
+// source line, if there is one.
+func (p *Program) InstructionAt(line int) (int, bool) {
+	for i, instructionLine := range p.Lines {
+		if instructionLine >= line {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (i Instruction) String() string {
+	return fmt.Sprintf("%s %d %d %d", i.operation, i.a, i.b, i.c)
 }
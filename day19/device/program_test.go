@@ -0,0 +1,107 @@
+package device
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReader(t *testing.T) {
+	input := "#ip 3\naddi 3 16 3\n\n  seti 1 0 4\n"
+	program, err := ParseReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseReader(%q) returned error %v", input, err)
+	}
+	expected := &Program{IP: 3,
+		Instructions: []Instruction{
+			{"addi", 3, 16, 3},
+			{"seti", 1, 0, 4},
+		},
+		Lines: []int{2, 4},
+	}
+	if !reflect.DeepEqual(program, expected) {
+		t.Errorf("ParseReader(%q) = %+v, expected %+v", input, program, expected)
+	}
+}
+
+func TestParseReaderErrors(t *testing.T) {
+	var tests = []struct {
+		input        string
+		line, column int
+		msg          string
+	}{
+		{"#ip 3\nfoo 1 2 3\n", 2, 1, `unknown opcode "foo"`},
+		{"#ip 3\n  addi 1 2\n", 2, 11, "addi takes 3 operand(s), got 2"},
+		{"#ip 3\naddi 1 2 3 4\n", 2, 12, "addi takes 3 operand(s), got 4"},
+		{"#ip 3\naddi 1 x 3\n", 2, 8, `operand "x" is not a number`},
+		{"#ip x\n", 1, 5, `operand "x" is not a number`},
+		{"#ip\n", 1, 4, "#ip takes 1 operand(s), got 0"},
+		{"#ip 1\naddi 1 2 3\n#ip 2\n", 3, 1, "duplicate #ip directive, first given on line 1"},
+		{"#foo 1\n", 1, 1, `unknown directive "#foo"`},
+		{"addi 1 2 3\n", 0, 0, "missing #ip directive"},
+		{"", 0, 0, "missing #ip directive"},
+	}
+	for _, test := range tests {
+		_, err := ParseReader(strings.NewReader(test.input))
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("ParseReader(%q) returned %v, expected a *ParseError", test.input, err)
+			continue
+		}
+		if parseErr.Line != test.line || parseErr.Column != test.column || parseErr.Msg != test.msg {
+			t.Errorf("ParseReader(%q) = %d:%d %q, expected %d:%d %q", test.input,
+				parseErr.Line, parseErr.Column, parseErr.Msg, test.line, test.column, test.msg)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	for _, filename := range []string{"../input.txt", "../../day21/input.txt"} {
+		program, err := ParseFile(filename)
+		if err != nil {
+			t.Fatalf("ParseFile(%q) returned error %v", filename, err)
+		}
+		if len(program.Instructions) != len(program.Lines) {
+			t.Errorf("ParseFile(%q) has %d instructions but %d lines", filename, len(program.Instructions), len(program.Lines))
+		}
+	}
+	if _, err := ParseFile("does-not-exist.txt"); !os.IsNotExist(err) {
+		t.Errorf("ParseFile of a missing file returned %v", err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(filename, []byte("#ip 0\nseti 1 0 1\nseti 1 x 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ParseFile(filename)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 3 || parseErr.Column != 8 {
+		t.Errorf("ParseFile of a bad listing returned %v", err)
+	}
+}
+
+func TestInstructionAt(t *testing.T) {
+	program, err := ParseReader(strings.NewReader("#ip 0\nseti 1 0 1\n\nseti 2 0 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		line     int
+		expected int
+		ok       bool
+	}{
+		{1, 0, true},
+		{2, 0, true},
+		{3, 1, true},
+		{4, 1, true},
+		{5, 0, false},
+	}
+	for _, test := range tests {
+		if i, ok := program.InstructionAt(test.line); i != test.expected || ok != test.ok {
+			t.Errorf("InstructionAt(%d) = %d, %v; expected %d, %v", test.line, i, ok, test.expected, test.ok)
+		}
+	}
+}
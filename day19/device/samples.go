@@ -0,0 +1,254 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NumericInstruction is an instruction whose opcode is given by number
+// rather than by name.
+type NumericInstruction struct {
+	Opcode, A, B, C int
+}
+
+// Sample records the registers before and after a numeric instruction ran.
+type Sample struct {
+	Before      []int
+	Instruction NumericInstruction
+	After       []int
+}
+
+// ParseSamples reads a numeric-opcode listing: samples of the form
+//
+//	Before: [3, 2, 1, 1]
+//	9 2 1 2
+//	After:  [3, 2, 2, 1]
+//
+// separated by blank lines, optionally followed by a program of numeric
+// instructions.
+func ParseSamples(r io.Reader) ([]Sample, []NumericInstruction, error) {
+	var samples []Sample
+	var program []NumericInstruction
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	next := func() (string, bool) {
+		for scanner.Scan() {
+			lineNumber++
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				return line, true
+			}
+		}
+		return "", false
+	}
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		if !strings.HasPrefix(line, "Before:") {
+			instruction, err := parseNumericInstruction(lineNumber, line)
+			if err != nil {
+				return nil, nil, err
+			}
+			program = append(program, instruction)
+			continue
+		}
+		if len(program) > 0 {
+			return nil, nil, &ParseError{lineNumber, 1, "sample after the program"}
+		}
+		var sample Sample
+		var err error
+		if sample.Before, err = parseRegisterList(lineNumber, line, "Before:"); err != nil {
+			return nil, nil, err
+		}
+		if line, ok = next(); !ok {
+			return nil, nil, &ParseError{Msg: "incomplete sample at end of listing"}
+		}
+		if sample.Instruction, err = parseNumericInstruction(lineNumber, line); err != nil {
+			return nil, nil, err
+		}
+		if line, ok = next(); !ok {
+			return nil, nil, &ParseError{Msg: "incomplete sample at end of listing"}
+		}
+		if sample.After, err = parseRegisterList(lineNumber, line, "After:"); err != nil {
+			return nil, nil, err
+		}
+		if len(sample.After) != len(sample.Before) {
+			return nil, nil, &ParseError{lineNumber, 1, fmt.Sprintf("%d registers after but %d before", len(sample.After), len(sample.Before))}
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return samples, program, nil
+}
+
+func parseRegisterList(lineNumber int, line, prefix string) ([]int, error) {
+	if !strings.HasPrefix(line, prefix) {
+		return nil, &ParseError{lineNumber, 1, fmt.Sprintf("expected %q", prefix)}
+	}
+	list := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+	if !strings.HasPrefix(list, "[") || !strings.HasSuffix(list, "]") {
+		return nil, &ParseError{lineNumber, len(prefix) + 1, "expected a register list such as [3, 2, 1, 1]"}
+	}
+	var registers []int
+	for _, value := range strings.Split(list[1:len(list)-1], ",") {
+		register, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, &ParseError{lineNumber, len(prefix) + 1, fmt.Sprintf("register value %q is not a number", strings.TrimSpace(value))}
+		}
+		registers = append(registers, register)
+	}
+	return registers, nil
+}
+
+func parseNumericInstruction(lineNumber int, line string) (NumericInstruction, error) {
+	fields := splitFields(line)
+	if len(fields) != 4 {
+		return NumericInstruction{}, &ParseError{lineNumber, 1, fmt.Sprintf("numeric instruction takes 4 numbers, got %d", len(fields))}
+	}
+	var values [4]int
+	for i, field := range fields {
+		value, err := strconv.Atoi(field.text)
+		if err != nil {
+			return NumericInstruction{}, &ParseError{lineNumber, field.column, fmt.Sprintf("%q is not a number", field.text)}
+		}
+		values[i] = value
+	}
+	return NumericInstruction{values[0], values[1], values[2], values[3]}, nil
+}
+
+// Candidates returns the names of the built-in operations that turn
+// s.Before into s.After, in alphabetical order.
+func (s Sample) Candidates() []string {
+	var names []string
+	registers := make([]int, len(s.Before))
+	for name, opcode := range basicOpcodes {
+		instruction := Instruction{name, s.Instruction.A, s.Instruction.B, s.Instruction.C}
+		if NewProgram(0, instruction).Validate(len(s.Before)) != nil {
+			continue
+		}
+		copy(registers, s.Before)
+		opcode.Execute(registers, instruction.a, instruction.b, instruction.c)
+		if equalRegisters(registers, s.After) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalRegisters(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveOpcodes works out which operation each opcode number stands for.
+// Each number can only be an operation consistent with all of its samples,
+// no two numbers share an operation and, if there are sixteen numbers, each
+// operation has one. Those constraints are propagated until every number
+// seen in the samples has a single operation. It is an
+// error if the samples contradict each other or leave a choice open.
+func ResolveOpcodes(samples []Sample) (map[int]string, error) {
+	possible := make(map[int]map[string]bool)
+	for _, sample := range samples {
+		number := sample.Instruction.Opcode
+		candidates := make(map[string]bool)
+		for _, name := range sample.Candidates() {
+			if set, seen := possible[number]; !seen || set[name] {
+				candidates[name] = true
+			}
+		}
+		possible[number] = candidates
+	}
+
+	mapping := make(map[int]string)
+	assign := func(number int, name string) {
+		mapping[number] = name
+		delete(possible, number)
+		for _, names := range possible {
+			delete(names, name)
+		}
+	}
+	for progress := true; progress; {
+		progress = false
+		for number, names := range possible {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("no operation is consistent with every sample of opcode %d", number)
+			}
+		}
+		// An opcode with a single possible operation takes it.
+		for number, names := range possible {
+			if len(names) == 1 {
+				for name := range names {
+					assign(number, name)
+				}
+				progress = true
+			}
+		}
+		// When the samples cover every operation, an operation possible for
+		// a single opcode belongs to it.
+		if len(mapping)+len(possible) < len(basicOpcodes) {
+			continue
+		}
+		numbersFor := make(map[string][]int)
+		for number, names := range possible {
+			for name := range names {
+				numbersFor[name] = append(numbersFor[name], number)
+			}
+		}
+		for name, numbers := range numbersFor {
+			if _, ok := possible[numbers[0]]; len(numbers) == 1 && ok && possible[numbers[0]][name] {
+				assign(numbers[0], name)
+				progress = true
+			}
+		}
+	}
+	if len(possible) > 0 {
+		var unresolved []string
+		for number, names := range possible {
+			var list []string
+			for name := range names {
+				list = append(list, name)
+			}
+			sort.Strings(list)
+			unresolved = append(unresolved, fmt.Sprintf("%d (%s)", number, strings.Join(list, ", ")))
+		}
+		sort.Strings(unresolved)
+		return nil, fmt.Errorf("opcodes left ambiguous: %s", strings.Join(unresolved, "; "))
+	}
+	return mapping, nil
+}
+
+// NumericProgram translates a numeric program into a Program using mapping.
+// Numeric programs have no #ip directive, so the IP register is bound to
+// register numRegisters, a spare one after those the program may use; run
+// it on a device with numRegisters+1 registers.
+func NumericProgram(mapping map[int]string, code []NumericInstruction, numRegisters int) (*Program, error) {
+	program := &Program{}
+	for i, numeric := range code {
+		name, ok := mapping[numeric.Opcode]
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: opcode %d is not in the mapping", i, numeric.Opcode)
+		}
+		program.Instructions = append(program.Instructions, Instruction{name, numeric.A, numeric.B, numeric.C})
+	}
+	// Checking against numRegisters keeps the program off the spare register.
+	if err := program.Validate(numRegisters); err != nil {
+		return nil, err
+	}
+	program.IP = numRegisters
+	return program, nil
+}
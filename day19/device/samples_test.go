@@ -0,0 +1,90 @@
+package device
+
+import (
+	"context"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSampleCandidates(t *testing.T) {
+	samples, _, err := ParseSamples(strings.NewReader("Before: [3, 2, 1, 1]\n9 2 1 2\nAfter:  [3, 2, 2, 1]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("Parsed %d samples, expected 1", len(samples))
+	}
+	if candidates := samples[0].Candidates(); !reflect.DeepEqual(candidates, []string{"addi", "mulr", "seti"}) {
+		t.Errorf("Candidates() = %v", candidates)
+	}
+}
+
+func TestParseSamplesErrors(t *testing.T) {
+	var tests = []struct {
+		input string
+		err   string
+	}{
+		{"Before: [3, 2, 1, 1]\n9 2 1 2\n", "incomplete sample at end of listing"},
+		{"Before: [3, 2, 1]\n9 2 1 2\nAfter:  [3, 2, 2, 1]\n", "line 3, column 1: 4 registers after but 3 before"},
+		{"Before: [3, x]\n", "line 1, column 8: register value \"x\" is not a number"},
+		{"Before: [3, 2, 1, 1]\n9 2 1\n", "line 2, column 1: numeric instruction takes 4 numbers, got 3"},
+		{"1 2 3 4\nBefore: [0]\n", "line 2, column 1: sample after the program"},
+	}
+	for _, test := range tests {
+		_, _, err := ParseSamples(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("ParseSamples(%q) returned error %v, expected %q", test.input, err, test.err)
+		}
+	}
+}
+
+func TestResolveOpcodes(t *testing.T) {
+	file, err := os.Open("testdata/samples.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	samples, code, err := ParseSamples(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 128 || len(code) != 18 {
+		t.Fatalf("Parsed %d samples and %d instructions", len(samples), len(code))
+	}
+	mapping, err := ResolveOpcodes(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]string{
+		0: "eqri", 1: "setr", 2: "addi", 3: "addr", 4: "eqrr", 5: "muli", 6: "eqir", 7: "bani",
+		8: "gtir", 9: "borr", 10: "gtrr", 11: "banr", 12: "gtri", 13: "bori", 14: "mulr", 15: "seti",
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("ResolveOpcodes() = %v, expected %v", mapping, expected)
+	}
+
+	program, err := NumericProgram(mapping, code, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := New(5)
+	result, err := d.Execute(context.Background(), program, math.MaxInt)
+	if err != nil || result.Reason != Halted || !equal(result.Registers[:4], []int{126, 0, 0, 126}) {
+		t.Errorf("Execute = %+v, %v", result, err)
+	}
+
+	if _, err := ResolveOpcodes(samples[:3]); err == nil || !strings.HasPrefix(err.Error(), "opcodes left ambiguous") {
+		t.Errorf("ResolveOpcodes with 3 samples returned %v", err)
+	}
+	contradiction := append([]Sample{}, samples[0], samples[0])
+	contradiction[1].After = []int{9, 9, 9, 9}
+	if _, err := ResolveOpcodes(contradiction); err == nil {
+		t.Error("ResolveOpcodes accepted contradicting samples")
+	}
+	if _, err := NumericProgram(mapping, []NumericInstruction{{3, 0, 1, 4}}, 4); err == nil {
+		t.Error("NumericProgram accepted a write to the spare register")
+	}
+}
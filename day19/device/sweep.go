@@ -0,0 +1,145 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// SweepConfig says how Sweep runs a program.
+type SweepConfig struct {
+	NumRegisters int
+	Budget       int // instructions per run
+	// Workers is the number of runs in progress at once. Zero means
+	// GOMAXPROCS.
+	Workers int
+	// Ordered delivers results in the order of the initial states rather
+	// than as runs complete.
+	Ordered bool
+	// Options are passed to New for each worker's device.
+	Options []Option
+}
+
+// SweepResult is the outcome of one run of a sweep.
+type SweepResult struct {
+	Index   int   // position of the initial state in the sweep
+	Initial []int // the initial registers
+	Result
+}
+
+// Sweep runs program from each initial state returned by next until it
+// returns false, spreading the runs over several devices, and sends the
+// results on the returned channel, which is closed once the sweep is done.
+// next is called from a single goroutine, and no more than a small window
+// of states, a few per worker, are taken ahead of the results received.
+// Cancelling ctx stops the sweep; results not yet received may be dropped.
+func Sweep(ctx context.Context, program *Program, next func() ([]int, bool), config SweepConfig) (<-chan SweepResult, error) {
+	// Check the options and program on one device, so that the workers'
+	// devices cannot fail.
+	d, err := newDevice(config.NumRegisters, config.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Load(program); err != nil {
+		return nil, err
+	}
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	window := 2 * workers
+	// A token is held by each state from being taken until its result is
+	// delivered, which bounds the states in flight and the results held
+	// back for ordering.
+	tokens := make(chan struct{}, window)
+	jobs := make(chan SweepResult)
+	results := make(chan SweepResult, window)
+	out := make(chan SweepResult)
+
+	go func() {
+		defer close(jobs)
+		for index := 0; ; index++ {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			initial, ok := next()
+			if !ok {
+				return
+			}
+			select {
+			case jobs <- SweepResult{Index: index, Initial: initial}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := New(config.NumRegisters, config.Options...)
+			_ = d.Load(program)
+			for job := range jobs {
+				job.Result = d.rerun(ctx, job.Initial, config.Budget)
+				results <- job
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(out)
+		send := func(result SweepResult) bool {
+			select {
+			case out <- result:
+				<-tokens
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		pending := make(map[int]SweepResult)
+		nextIndex := 0
+	receive:
+		for result := range results {
+			if !config.Ordered {
+				if !send(result) {
+					break
+				}
+				continue
+			}
+			pending[result.Index] = result
+			for result, ok := pending[nextIndex]; ok; result, ok = pending[nextIndex] {
+				if !send(result) {
+					break receive
+				}
+				delete(pending, nextIndex)
+				nextIndex++
+			}
+		}
+		// Let the workers finish if the receiver has gone.
+		for range results {
+		}
+	}()
+	return out, nil
+}
+
+// rerun runs the loaded program afresh from initial.
+func (d *Device) rerun(ctx context.Context, initial []int, budget int) Result {
+	if len(initial) != len(d.Registers) {
+		return d.result(Fault, fmt.Errorf("initial state has %d registers, not %d", len(initial), len(d.Registers)))
+	}
+	copy(d.Registers, initial)
+	d.IP = 0
+	d.Executed = 0
+	d.stoppedAtBreakpoint = false
+	return d.RunContext(ctx, budget)
+}
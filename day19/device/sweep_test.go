@@ -0,0 +1,146 @@
+package device
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// triangle leaves r0*(r0+1)/2 in r4, taking about 4*r0 instructions.
+const triangle = `#ip 3
+setr 0 0 1
+gtri 1 0 2
+addr 3 2 3
+seti 99 0 3
+addr 4 1 4
+addi 1 -1 1
+seti 0 0 3
+`
+
+// countingStates returns a generator of states with r0 counting from 0 to
+// n-1, or for ever if n is negative, and a count of the states taken.
+func countingStates(n int) (func() ([]int, bool), *int64) {
+	var taken int64
+	return func() ([]int, bool) {
+		i := atomic.AddInt64(&taken, 1) - 1
+		if n >= 0 && i >= int64(n) {
+			return nil, false
+		}
+		// Alternate long and short runs so that they finish out of order.
+		r0 := int(i)
+		if i%2 == 0 {
+			r0 = 100 - int(i)
+		}
+		return []int{r0, 0, 0, 0, 0}, true
+	}, &taken
+}
+
+func TestSweep(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(triangle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const runs, budget = 100, 300
+	var want []SweepResult
+	next, _ := countingStates(runs)
+	for index := 0; ; index++ {
+		initial, ok := next()
+		if !ok {
+			break
+		}
+		d := New(5)
+		copy(d.Registers, initial)
+		result, err := d.Execute(context.Background(), program, budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, SweepResult{index, initial, result})
+	}
+	for _, ordered := range []bool{true, false} {
+		next, _ := countingStates(runs)
+		results, err := Sweep(context.Background(), program, next, SweepConfig{NumRegisters: 5, Budget: budget, Workers: 4, Ordered: ordered})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []SweepResult
+		for result := range results {
+			got = append(got, result)
+		}
+		if !ordered {
+			sort.Slice(got, func(i, j int) bool { return got[i].Index < got[j].Index })
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ordered %v: results differ from running each state in turn", ordered)
+		}
+	}
+}
+
+func TestSweepBounded(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(triangle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next, taken := countingStates(-1)
+	const workers = 3
+	results, err := Sweep(ctx, program, next, SweepConfig{NumRegisters: 5, Budget: 1000, Workers: workers, Ordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if result := <-results; result.Index != i {
+			t.Fatalf("got result %d, want %d", result.Index, i)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(taken); n > 10+2*workers {
+		t.Errorf("took %d states with 10 results received", n)
+	}
+	cancel()
+	for range results {
+	}
+}
+
+func TestSweepInvalid(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(triangle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sweep(context.Background(), program, nil, SweepConfig{NumRegisters: 4}); err == nil {
+		t.Error("Sweep accepted a program using more registers than configured")
+	}
+	for _, config := range []SweepConfig{
+		{NumRegisters: -1},
+		{NumRegisters: 5, Options: []Option{Width(1)}},
+		{NumRegisters: 5, Options: []Option{OnOverflow(Trap + 1)}},
+	} {
+		if _, err := Sweep(context.Background(), program, nil, config); err == nil {
+			t.Errorf("Sweep accepted %+v", config)
+		}
+	}
+	states := [][]int{{1, 0, 0, 0, 0}, {1, 0}}
+	next := func() ([]int, bool) {
+		if len(states) == 0 {
+			return nil, false
+		}
+		state := states[0]
+		states = states[1:]
+		return state, true
+	}
+	results, err := Sweep(context.Background(), program, next, SweepConfig{NumRegisters: 5, Budget: 100, Ordered: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reasons []HaltReason
+	for result := range results {
+		reasons = append(reasons, result.Reason)
+	}
+	if !reflect.DeepEqual(reasons, []HaltReason{Halted, Fault}) {
+		t.Errorf("got %v", reasons)
+	}
+}
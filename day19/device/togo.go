@@ -0,0 +1,104 @@
+package device
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// ToGo translates the program into a Go source file for package
+// packageName. Writes to the IP register become gotos when the target is
+// known, and a switch over the possible targets otherwise. The file
+// declares
+//
+//	func Run(r [N]int) [N]int
+//	func RunLimit(r [N]int, limit int) (registers [N]int, ip, executed int, halted bool)
+//
+// where N is numRegisters. RunLimit stops after limit instructions unless
+// limit is negative, and reports the state the same way Device does.
+func (p *Program) ToGo(packageName string, numRegisters int) (string, error) {
+	if err := p.Validate(numRegisters); err != nil {
+		return "", err
+	}
+	g := p.CFG()
+	n := len(p.Instructions)
+
+	// Only targets of gotos get labels, as Go rejects unused ones.
+	labelled := make([]bool, n)
+	for i, jump := range g.Jumps {
+		switch jump.Kind {
+		case ConstantJump, ComputedJump:
+			for _, target := range jump.Targets {
+				if target >= 0 && target < n && !(jump.Kind == ConstantJump && target == i+1) {
+					labelled[target] = true
+				}
+			}
+		case UnresolvedJump:
+			for target := range labelled {
+				labelled[target] = true
+			}
+		}
+	}
+
+	regs := fmt.Sprintf("[%d]int", numRegisters)
+	var sb strings.Builder
+	sb.WriteString("// Code generated by device.Program.ToGo; DO NOT EDIT.\n\n")
+	sb.WriteString(fmt.Sprintf("package %s\n\n", packageName))
+	sb.WriteString("// Run executes the program from registers r until it halts.\n")
+	sb.WriteString(fmt.Sprintf("func Run(r %s) %s {\n", regs, regs))
+	sb.WriteString("\tr, _, _, _ = RunLimit(r, -1)\n\treturn r\n}\n\n")
+	sb.WriteString("// RunLimit executes at most limit instructions, or until the program\n")
+	sb.WriteString("// halts if limit is negative. It returns the registers, the instruction\n")
+	sb.WriteString("// pointer, the number of instructions executed and whether the program\n")
+	sb.WriteString("// halted.\n")
+	sb.WriteString(fmt.Sprintf("func RunLimit(r %s, limit int) (%s, int, int, bool) {\n", regs, regs))
+	sb.WriteString("\tn := 0\n")
+	for i, instruction := range p.Instructions {
+		if labelled[i] {
+			sb.WriteString(fmt.Sprintf("I%d:\n", i))
+		}
+		sb.WriteString(fmt.Sprintf("\tif n == limit {\n\t\treturn r, %d, n, false\n\t}\n", i))
+		sb.WriteString("\tn++\n")
+		sb.WriteString(fmt.Sprintf("\tr[%d] = %d\n", p.IP, i))
+		opcode, _ := LookupOpcode(instruction.operation)
+		sb.WriteString(opcode.Go(instruction.a, instruction.b, instruction.c))
+		sb.WriteByte('\n')
+
+		jump := g.Jumps[i]
+		switch jump.Kind {
+		case Fallthrough:
+			if i == n-1 {
+				sb.WriteString(fmt.Sprintf("\treturn r, %d, n, true\n", n))
+			}
+		case ConstantJump:
+			target := jump.Targets[0]
+			if target < 0 || target >= n {
+				sb.WriteString(fmt.Sprintf("\treturn r, %d, n, true\n", target))
+			} else if target != i+1 {
+				sb.WriteString(fmt.Sprintf("\tgoto I%d\n", target))
+			}
+		default:
+			targets := jump.Targets
+			if jump.Kind == UnresolvedJump {
+				targets = make([]int, n)
+				for target := range targets {
+					targets[target] = target
+				}
+			}
+			sb.WriteString(fmt.Sprintf("\tswitch r[%d] + 1 {\n", p.IP))
+			for _, target := range targets {
+				if target >= 0 && target < n {
+					sb.WriteString(fmt.Sprintf("\tcase %d:\n\t\tgoto I%d\n", target, target))
+				}
+			}
+			sb.WriteString(fmt.Sprintf("\tdefault:\n\t\treturn r, r[%d] + 1, n, true\n\t}\n", p.IP))
+		}
+	}
+	sb.WriteString("}\n")
+
+	source, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return "", fmt.Errorf("generated invalid Go: %v", err)
+	}
+	return string(source), nil
+}
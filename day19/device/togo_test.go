@@ -0,0 +1,209 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToGoTypeChecks(t *testing.T) {
+	for _, filename := range []string{"../input.txt", "../../day21/input.txt"} {
+		program, err := ParseFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, err := program.ToGo("elfcode", 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "elfcode.go", source, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		if _, err := new(types.Config).Check("elfcode", fset, []*ast.File{file}, nil); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+		if !strings.Contains(source, "func Run(r [6]int) [6]int {") {
+			t.Errorf("%s: generated code does not declare Run", filename)
+		}
+	}
+}
+
+func TestToGoInvalid(t *testing.T) {
+	program := &Program{IP: 0, Instructions: []Instruction{{"addr", 7, 0, 1}}}
+	if _, err := program.ToGo("elfcode", 6); err == nil {
+		t.Error("ToGo accepted an invalid program")
+	}
+}
+
+// toGoRun is one call to the generated RunLimit.
+type toGoRun struct {
+	Registers [6]int
+	Limit     int
+}
+
+type toGoResult struct {
+	Registers [6]int
+	IP        int
+	Executed  int
+	Halted    bool
+}
+
+// TestToGoMatchesDevice builds the generated code with the go tool and
+// checks that it stops in the same state as the interpreter.
+func TestToGoMatchesDevice(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	var tests = []struct {
+		filename string
+		runs     []toGoRun
+	}{
+		{"../input.txt", []toGoRun{
+			{[6]int{}, -1},
+			{[6]int{1}, 100000},
+		}},
+		{"../../day21/input.txt", []toGoRun{
+			{[6]int{15823996}, -1},
+			{[6]int{}, 1000},
+			{[6]int{}, 0},
+		}},
+		{"testdata/computed.txt", []toGoRun{
+			{[6]int{}, -1},
+			{[6]int{3}, -1},
+			{[6]int{-5}, -1},
+		}},
+	}
+	for _, test := range tests {
+		program, err := ParseFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := runGenerated(t, goTool, program, test.runs)
+		for i, run := range test.runs {
+			if expected := runDevice(t, program, run); !reflect.DeepEqual(results[i], expected) {
+				t.Errorf("%s run %d: generated code stopped with %+v, expected %+v", test.filename, i, results[i], expected)
+			}
+		}
+	}
+}
+
+// TestToGoRandomPrograms checks the generated code against the device on
+// the random programs used for fuzzing.
+func TestToGoRandomPrograms(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	runs := []toGoRun{
+		{[6]int{}, fuzzBudget},
+		{[6]int{7, -3}, fuzzBudget},
+		{[6]int{1 << 30, 5, 5, 5, 5, 5}, fuzzBudget},
+	}
+	for i, program := range randomPrograms(8, 30) {
+		results := runGenerated(t, goTool, program, runs)
+		for j, run := range runs {
+			if expected := runDevice(t, program, run); !reflect.DeepEqual(results[j], expected) {
+				t.Errorf("program %d %v run %d: generated code stopped with %+v, expected %+v", i, program.Instructions, j, results[j], expected)
+			}
+		}
+	}
+}
+
+// runGenerated builds the code ToGo generates for program with the go tool
+// and returns how each of runs stops.
+func runGenerated(t *testing.T, goTool string, program *Program, runs []toGoRun) []toGoResult {
+	t.Helper()
+	source, err := program.ToGo("main", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":     "module elfcode\n\ngo 1.18\n",
+		"elfcode.go": source,
+		"main.go": fmt.Sprintf(`package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+func main() {
+	var runs []struct {
+		Registers [6]int
+		Limit     int
+	}
+	if err := json.Unmarshal([]byte(%q), &runs); err != nil {
+		panic(err)
+	}
+	var results []interface{}
+	for _, run := range runs {
+		registers, ip, executed, halted := RunLimit(run.Registers, run.Limit)
+		results = append(results, map[string]interface{}{"Registers": registers, "IP": ip, "Executed": executed, "Halted": halted})
+	}
+	json.NewEncoder(os.Stdout).Encode(results)
+}
+`, encoded),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go run: %v", err)
+	}
+	var results []toGoResult
+	if err := json.Unmarshal(output, &results); err != nil {
+		t.Fatalf("%v in %q", err, output)
+	}
+	return results
+}
+
+// runDevice returns how run stops on a device.
+func runDevice(t *testing.T, program *Program, run toGoRun) toGoResult {
+	t.Helper()
+	d := New(6)
+	copy(d.Registers, run.Registers[:])
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	if run.Limit < 0 {
+		result = d.Continue()
+	} else {
+		result = d.Run(run.Limit)
+	}
+	var expected toGoResult
+	copy(expected.Registers[:], result.Registers)
+	expected.IP = result.IP
+	expected.Executed = result.Instructions
+	expected.Halted = result.Reason == Halted
+	return expected
+}
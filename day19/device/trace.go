@@ -0,0 +1,83 @@
+package device
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+)
+
+// TraceRecord describes one executed instruction. Before and After are only
+// valid during the call to Trace; tracers that keep them must copy them.
+type TraceRecord struct {
+	Step          int // instructions executed so far, including this one
+	IP            int
+	Instruction   Instruction
+	Before, After []int
+}
+
+// Tracer observes execution one instruction at a time.
+type Tracer interface {
+	Trace(record *TraceRecord)
+}
+
+// JSONTracer writes one JSON object per line for each traced instruction.
+type JSONTracer struct {
+	w            io.Writer
+	every        int
+	minIP, maxIP int
+	matched      int
+	err          error
+}
+
+type jsonTraceRecord struct {
+	Step   int    `json:"step"`
+	IP     int    `json:"ip"`
+	Op     string `json:"op"`
+	A      int    `json:"a"`
+	B      int    `json:"b"`
+	C      int    `json:"c"`
+	Before []int  `json:"before"`
+	After  []int  `json:"after"`
+}
+
+// NewJSONTracer returns a tracer that records every instruction to w.
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{w: w, every: 1, minIP: math.MinInt, maxIP: math.MaxInt}
+}
+
+// Sample records only every nth instruction that passes the IP filter.
+func (t *JSONTracer) Sample(n int) *JSONTracer {
+	if n < 1 {
+		n = 1
+	}
+	t.every = n
+	return t
+}
+
+// IPRange records only instructions with min <= ip <= max.
+func (t *JSONTracer) IPRange(min, max int) *JSONTracer {
+	t.minIP, t.maxIP = min, max
+	return t
+}
+
+func (t *JSONTracer) Trace(record *TraceRecord) {
+	if t.err != nil || record.IP < t.minIP || record.IP > t.maxIP {
+		return
+	}
+	t.matched++
+	if (t.matched-1)%t.every != 0 {
+		return
+	}
+	instruction := record.Instruction
+	t.err = json.NewEncoder(t.w).Encode(jsonTraceRecord{
+		record.Step, record.IP,
+		instruction.operation, instruction.a, instruction.b, instruction.c,
+		record.Before, record.After,
+	})
+}
+
+// Err returns the first error writing the trace, if any. Tracing stops
+// after an error.
+func (t *JSONTracer) Err() error {
+	return t.err
+}
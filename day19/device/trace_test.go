@@ -0,0 +1,79 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func traceSteps(t *testing.T, tracer func(io.Writer) *JSONTracer) []jsonTraceRecord {
+	program := &Program{IP: 0,
+		Instructions: []Instruction{
+			{"seti", 5, 0, 1},
+			{"seti", 6, 0, 2},
+			{"addi", 0, 1, 0},
+			{"addr", 1, 2, 3},
+			{"setr", 1, 0, 0},
+			{"seti", 8, 0, 4},
+			{"seti", 9, 0, 5},
+		},
+	}
+	var buffer bytes.Buffer
+	testDevice := New(6)
+	jsonTracer := tracer(&buffer)
+	testDevice.Tracer = jsonTracer
+	if _, err := testDevice.Execute(context.Background(), program, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+	if jsonTracer.Err() != nil {
+		t.Fatal(jsonTracer.Err())
+	}
+
+	var records []jsonTraceRecord
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var record jsonTraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Bad trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestJSONTracer(t *testing.T) {
+	records := traceSteps(t, NewJSONTracer)
+	if len(records) != 5 {
+		t.Fatalf("Traced %d instructions, expected 5", len(records))
+	}
+	expected := []jsonTraceRecord{
+		{1, 0, "seti", 5, 0, 1, []int{0, 0, 0, 0, 0, 0}, []int{0, 5, 0, 0, 0, 0}},
+		{2, 1, "seti", 6, 0, 2, []int{1, 5, 0, 0, 0, 0}, []int{1, 5, 6, 0, 0, 0}},
+		{3, 2, "addi", 0, 1, 0, []int{2, 5, 6, 0, 0, 0}, []int{3, 5, 6, 0, 0, 0}},
+		{4, 4, "setr", 1, 0, 0, []int{4, 5, 6, 0, 0, 0}, []int{5, 5, 6, 0, 0, 0}},
+		{5, 6, "seti", 9, 0, 5, []int{6, 5, 6, 0, 0, 0}, []int{6, 5, 6, 0, 0, 9}},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Trace = %v, expected %v", records, expected)
+	}
+}
+
+func TestJSONTracerFilters(t *testing.T) {
+	sampled := traceSteps(t, func(w io.Writer) *JSONTracer { return NewJSONTracer(w).Sample(2) })
+	if len(sampled) != 3 || sampled[0].Step != 1 || sampled[1].Step != 3 || sampled[2].Step != 5 {
+		t.Errorf("Sample(2) traced %v", sampled)
+	}
+	ranged := traceSteps(t, func(w io.Writer) *JSONTracer { return NewJSONTracer(w).IPRange(2, 4) })
+	if len(ranged) != 2 || ranged[0].IP != 2 || ranged[1].IP != 4 {
+		t.Errorf("IPRange(2, 4) traced %v", ranged)
+	}
+	both := traceSteps(t, func(w io.Writer) *JSONTracer { return NewJSONTracer(w).IPRange(1, 6).Sample(3) })
+	if len(both) != 2 || both[0].Step != 2 || both[1].Step != 5 {
+		t.Errorf("IPRange(1, 6).Sample(3) traced %v", both)
+	}
+}
@@ -0,0 +1,96 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownOperation   = errors.New("unknown operation")
+	ErrRegisterOutOfRange = errors.New("register out of range")
+	ErrIPOutOfRange       = errors.New("#ip register out of range")
+)
+
+// InstructionError describes an instruction that cannot run on a device.
+type InstructionError struct {
+	Index       int // position in Program.Instructions
+	Line        int // source line, 0 if unknown
+	Instruction Instruction
+	Operand     byte // 'a', 'b' or 'c', or 0 if the whole instruction is at fault
+	Err         error
+}
+
+func (e *InstructionError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("instruction %d", e.Index))
+	if e.Line > 0 {
+		sb.WriteString(fmt.Sprintf(" (line %d)", e.Line))
+	}
+	sb.WriteString(fmt.Sprintf(" %v", e.Instruction))
+	if e.Operand != 0 {
+		sb.WriteString(fmt.Sprintf(": operand %c", e.Operand))
+	}
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *InstructionError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError collects every problem found by Program.Validate.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid program: %s", strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// Validate checks that program can run on a device with numRegisters
+// registers: the #ip register and every register operand must lie inside
+// the register file. All offending instructions are reported.
+func (p *Program) Validate(numRegisters int) error {
+	var errs []error
+	if p.IP < 0 || p.IP >= numRegisters {
+		errs = append(errs, fmt.Errorf("%w: #ip %d with %d registers", ErrIPOutOfRange, p.IP, numRegisters))
+	}
+	for i, instruction := range p.Instructions {
+		newError := func(operand byte, err error) *InstructionError {
+			instructionError := &InstructionError{Index: i, Instruction: instruction, Operand: operand, Err: err}
+			if i < len(p.Lines) {
+				instructionError.Line = p.Lines[i]
+			}
+			return instructionError
+		}
+		opcode, ok := LookupOpcode(instruction.operation)
+		if !ok {
+			errs = append(errs, newError(0, ErrUnknownOperation))
+			continue
+		}
+		a, b := opcode.Operands()
+		values := [3]int{instruction.a, instruction.b, instruction.c}
+		for operand, kind := range [3]OperandKind{a, b, RegisterOperand} {
+			if kind != RegisterOperand {
+				continue
+			}
+			if values[operand] < 0 || values[operand] >= numRegisters {
+				errs = append(errs, newError(byte('a'+operand), ErrRegisterOutOfRange))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+	return nil
+}
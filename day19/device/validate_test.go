@@ -0,0 +1,72 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	program := &Program{IP: 3,
+		Instructions: []Instruction{
+			{"addr", 0, 9, 3},
+			{"seti", 99, 0, 1},
+			{"gtir", 99, 1, 6},
+			{"addi", -1, 7, 2},
+			{"nope", 0, 0, 0},
+		},
+		Lines: []int{2, 3, 4, 5, 6},
+	}
+	err := program.Validate(6)
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("Validate returned %v, expected a *ValidationError", err)
+	}
+	var expected = []struct {
+		index   int
+		line    int
+		operand byte
+		err     error
+	}{
+		{0, 2, 'b', ErrRegisterOutOfRange},
+		{2, 4, 'c', ErrRegisterOutOfRange},
+		{3, 5, 'a', ErrRegisterOutOfRange},
+		{4, 6, 0, ErrUnknownOperation},
+	}
+	if len(validationError.Errors) != len(expected) {
+		t.Fatalf("Validate found %d errors, expected %d: %v", len(validationError.Errors), len(expected), err)
+	}
+	for i, e := range expected {
+		var instructionError *InstructionError
+		if !errors.As(validationError.Errors[i], &instructionError) {
+			t.Errorf("Error %d is %v, expected an *InstructionError", i, validationError.Errors[i])
+			continue
+		}
+		if instructionError.Index != e.index || instructionError.Line != e.line ||
+			instructionError.Operand != e.operand || !errors.Is(instructionError, e.err) {
+			t.Errorf("Error %d = %v, expected index %d line %d operand %q %v", i, instructionError, e.index, e.line, e.operand, e.err)
+		}
+	}
+	if !errors.Is(err, ErrRegisterOutOfRange) {
+		t.Errorf("errors.Is(%v, ErrRegisterOutOfRange) = false", err)
+	}
+}
+
+func TestValidateIP(t *testing.T) {
+	program := &Program{IP: 6, Instructions: []Instruction{{"seti", 1, 0, 0}}}
+	if err := program.Validate(6); !errors.Is(err, ErrIPOutOfRange) {
+		t.Errorf("Validate(6) with #ip 6 = %v, expected ErrIPOutOfRange", err)
+	}
+	if err := program.Validate(7); err != nil {
+		t.Errorf("Validate(7) with #ip 6 = %v, expected nil", err)
+	}
+}
+
+func TestExecuteInvalid(t *testing.T) {
+	program := &Program{IP: 0, Instructions: []Instruction{{"addr", 0, 9, 3}}}
+	result, err := New(6).Execute(context.Background(), program, math.MaxInt32)
+	if !errors.Is(err, ErrRegisterOutOfRange) || result.Reason != Fault || result.Err != err {
+		t.Errorf("Execute of invalid program = %v, %v", result.Reason, err)
+	}
+}
@@ -0,0 +1,192 @@
+package device
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Option configures a Device made by New.
+type Option func(*config)
+
+type config struct {
+	bits     int
+	unsigned bool
+	overflow Overflow
+}
+
+// Overflow says what a device does with a result that does not fit in its
+// registers.
+type Overflow int
+
+const (
+	// Wrap keeps the low bits of the result, as hardware registers do.
+	Wrap Overflow = iota
+	// Saturate stores the representable value nearest the result.
+	Saturate
+	// Trap stops execution with a Fault whose error is an *OverflowError,
+	// leaving the result register unchanged.
+	Trap
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Wrap:
+		return "wrap"
+	case Saturate:
+		return "saturate"
+	case Trap:
+		return "trap"
+	}
+	return fmt.Sprintf("Overflow(%d)", int(o))
+}
+
+// Width makes registers bits wide, at most strconv.IntSize. They hold two's
+// complement values unless Unsigned is also given. Without it registers are
+// Go ints, whose width depends on the host.
+func Width(bits int) Option {
+	return func(c *config) { c.bits = bits }
+}
+
+// Unsigned makes registers hold values from 0 to 2^bits-1. Unsigned
+// registers are at most strconv.IntSize-1 bits wide.
+func Unsigned() Option {
+	return func(c *config) { c.unsigned = true }
+}
+
+// OnOverflow sets what happens when a result does not fit in a register.
+// The default is Wrap.
+func OnOverflow(overflow Overflow) Option {
+	return func(c *config) { c.overflow = overflow }
+}
+
+// OverflowError reports an instruction whose result did not fit in a
+// register of a device that traps on overflow.
+type OverflowError struct {
+	IP          int
+	Instruction Instruction
+	Bits        int
+	Unsigned    bool
+}
+
+func (e *OverflowError) Error() string {
+	kind := "signed"
+	if e.Unsigned {
+		kind = "unsigned"
+	}
+	return fmt.Sprintf("instruction %d %v: result does not fit in %d-bit %s register", e.IP, e.Instruction, e.Bits, kind)
+}
+
+// width holds the register range of a device whose registers are not plain
+// Go ints. Results are computed in int64 and fitted to the range, so they do
+// not depend on the size of int.
+type width struct {
+	config
+	min, max int64
+}
+
+// newWidth checks c and returns the register width it describes, or nil
+// for signed registers as wide as an int that wrap, which are native ints.
+func newWidth(c config) (*width, error) {
+	if c.bits == 0 {
+		c.bits = strconv.IntSize
+	}
+	switch {
+	case c.unsigned && (c.bits < 1 || c.bits > strconv.IntSize-1):
+		return nil, fmt.Errorf("device: unsigned registers must be 1 to %d bits wide, not %d", strconv.IntSize-1, c.bits)
+	case !c.unsigned && (c.bits < 2 || c.bits > strconv.IntSize):
+		return nil, fmt.Errorf("device: signed registers must be 2 to %d bits wide, not %d", strconv.IntSize, c.bits)
+	case c.overflow < Wrap || c.overflow > Trap:
+		return nil, fmt.Errorf("device: invalid overflow behaviour %v", c.overflow)
+	}
+	if c.bits == strconv.IntSize && !c.unsigned && c.overflow == Wrap {
+		return nil, nil
+	}
+	w := &width{config: c}
+	if c.unsigned {
+		w.max = 1<<c.bits - 1
+	} else {
+		w.min = -1 << (c.bits - 1)
+		w.max = 1<<(c.bits-1) - 1
+	}
+	return w, nil
+}
+
+// execute runs instruction on registers, fitting its result to the
+// register width. It returns false, changing nothing, if the device traps
+// on the result.
+func (w *width) execute(registers []int, instruction Instruction) bool {
+	opcode, ok := basicOpcodes[instruction.operation]
+	if !ok {
+		// Other opcodes compute in Go ints; only their result is fitted.
+		c := instruction.c
+		old := registers[c]
+		custom, _ := LookupOpcode(instruction.operation)
+		custom.Execute(registers, instruction.a, instruction.b, c)
+		value, ok := w.fit(int64(registers[c]), true, false)
+		if !ok {
+			registers[c] = old
+			return false
+		}
+		registers[c] = int(value)
+		return true
+	}
+	a, b := int64(instruction.a), int64(instruction.b)
+	if opcode.a == RegisterOperand {
+		a = int64(registers[instruction.a])
+	}
+	if opcode.b == RegisterOperand {
+		b = int64(registers[instruction.b])
+	}
+	// exact is false when the result overflowed an int64, in which case
+	// negative gives its sign.
+	var value int64
+	exact, negative := true, false
+	switch opcode.operator {
+	case "+":
+		value = a + b
+		exact = (a < 0) != (b < 0) || (value < 0) == (a < 0)
+		negative = a < 0
+	case "*":
+		value = a * b
+		exact = a == 0 || value/a == b && !(a == -1 && b == math.MinInt64)
+		negative = (a < 0) != (b < 0)
+	case "&":
+		value = a & b
+	case "|":
+		value = a | b
+	case "":
+		value = a
+	case ">":
+		value = int64(boolToInt(a > b))
+	case "==":
+		value = int64(boolToInt(a == b))
+	}
+	value, ok = w.fit(value, exact, negative)
+	if ok {
+		registers[instruction.c] = int(value)
+	}
+	return ok
+}
+
+// fit returns the register value for a result whose low 64 bits are
+// value.
+func (w *width) fit(value int64, exact, negative bool) (int64, bool) {
+	if exact && value >= w.min && value <= w.max {
+		return value, true
+	}
+	switch w.overflow {
+	case Saturate:
+		if exact && value < w.min || !exact && negative {
+			return w.min, true
+		}
+		return w.max, true
+	case Trap:
+		return 0, false
+	}
+	if w.unsigned {
+		return value & w.max, true
+	}
+	shift := 64 - w.bits
+	return value << shift >> shift, true
+}
@@ -0,0 +1,156 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWidth(t *testing.T) {
+	// 300*300 = 90000, then *3 = 270000.
+	const multiply = "#ip 5\nseti 300 0 0\nmulr 0 0 0\nmuli 0 3 0\n"
+	// 255|4096 = 4351, then &3840, then *16.
+	const bitwise = "#ip 5\nseti 255 0 1\nbori 1 4096 1\nbani 1 3840 2\nmuli 2 16 3\n"
+	const negative = "#ip 5\nseti -1 0 0\n"
+	large := fmt.Sprintf("#ip 5\nseti %d 0 0\naddi 0 1 1\nmulr 0 0 2\nmuli 0 -1 3\n", math.MaxInt)
+	tests := []struct {
+		name      string
+		listing   string
+		options   []Option
+		reason    HaltReason
+		registers []int
+	}{
+		{"native", multiply, nil, Halted, []int{270000, 0, 0, 0, 0, 2}},
+		{"32-bit wrap", multiply, []Option{Width(32)}, Halted, []int{270000, 0, 0, 0, 0, 2}},
+		{"16-bit wrap", multiply, []Option{Width(16)}, Halted, []int{7856, 0, 0, 0, 0, 2}},
+		{"16-bit saturate", multiply, []Option{Width(16), OnOverflow(Saturate)}, Halted, []int{32767, 0, 0, 0, 0, 2}},
+		{"16-bit trap", multiply, []Option{Width(16), OnOverflow(Trap)}, Fault, []int{300, 0, 0, 0, 0, 1}},
+		{"17-bit unsigned wrap", multiply, []Option{Width(17), Unsigned()}, Halted, []int{7856, 0, 0, 0, 0, 2}},
+		{"12-bit unsigned wrap", bitwise, []Option{Width(12), Unsigned()}, Halted, []int{0, 255, 0, 0, 0, 3}},
+		{"12-bit unsigned saturate", bitwise, []Option{Width(12), Unsigned(), OnOverflow(Saturate)}, Halted, []int{0, 4095, 3840, 4095, 0, 3}},
+		{"12-bit unsigned trap", bitwise, []Option{Width(12), Unsigned(), OnOverflow(Trap)}, Fault, []int{0, 255, 0, 0, 0, 1}},
+		{"13-bit unsigned trap", bitwise, []Option{Width(13), Unsigned(), OnOverflow(Trap)}, Halted, []int{0, 4351, 0, 0, 0, 3}},
+		{"8-bit unsigned wrap", negative, []Option{Width(8), Unsigned()}, Halted, []int{255, 0, 0, 0, 0, 0}},
+		{"8-bit unsigned saturate", negative, []Option{Width(8), Unsigned(), OnOverflow(Saturate)}, Halted, []int{0, 0, 0, 0, 0, 0}},
+		{"8-bit signed", negative, []Option{Width(8), OnOverflow(Trap)}, Halted, []int{-1, 0, 0, 0, 0, 0}},
+		{"int-wide wrap", large, []Option{Width(strconv.IntSize), OnOverflow(Wrap)}, Halted, []int{math.MaxInt, math.MinInt, 1, -math.MaxInt, 0, 3}},
+		{"int-wide saturate", large, []Option{OnOverflow(Saturate)}, Halted, []int{math.MaxInt, math.MaxInt, math.MaxInt, -math.MaxInt, 0, 3}},
+		{"int-wide trap", large, []Option{OnOverflow(Trap)}, Fault, []int{math.MaxInt, 0, 0, 0, 0, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, err := ParseReader(strings.NewReader(test.listing))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := New(6, test.options...)
+			result, err := d.Execute(context.Background(), program, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Reason != test.reason || !reflect.DeepEqual(result.Registers, test.registers) {
+				t.Fatalf("got %v %v, want %v %v", result.Reason, result.Registers, test.reason, test.registers)
+			}
+			if test.reason != Fault {
+				return
+			}
+			var overflow *OverflowError
+			if !errors.As(result.Err, &overflow) || overflow.IP != result.IP || overflow.Instruction != program.Instructions[result.IP] {
+				t.Errorf("got error %v at instruction %d", result.Err, result.IP)
+			}
+			if result.Instructions != result.IP {
+				t.Errorf("executed %d instructions before the fault at %d", result.Instructions, result.IP)
+			}
+		})
+	}
+}
+
+// TestWidthLCG runs a linear congruential generator on 32-bit registers
+// and checks it against Go's int32 arithmetic.
+func TestWidthLCG(t *testing.T) {
+	program, err := ParseReader(strings.NewReader(`#ip 5
+muli 0 1103515245 0
+addi 0 12345 0
+addi 1 1 1
+gtri 1 99 2
+addr 5 2 5
+seti -1 0 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := int32(1)
+	for i := 0; i < 100; i++ {
+		want = want*1103515245 + 12345
+	}
+	for _, interpret := range []bool{false, true} {
+		d := New(6, Width(32))
+		d.Interpret = interpret
+		d.Registers[0] = 1
+		if _, err := d.Execute(context.Background(), program, 10000); err != nil {
+			t.Fatal(err)
+		}
+		if d.Registers[0] != int(want) {
+			t.Errorf("interpret %v: got %d, want %d", interpret, d.Registers[0], want)
+		}
+	}
+}
+
+// TestWidthDay21 drops the masks from day 21's program and checks that
+// 24-bit unsigned registers make up for them.
+func TestWidthDay21(t *testing.T) {
+	program, err := ParseFile("../../day21/input.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmasked := NewProgram(program.IP)
+	for _, instruction := range program.Instructions {
+		if instruction.operation == "bani" && instruction.b == 16777215 {
+			instruction = NewInstruction("setr", instruction.a, 0, instruction.c)
+		}
+		unmasked.Instructions = append(unmasked.Instructions, instruction)
+	}
+	haltValues := func(d *Device, program *Program) []int {
+		if err := d.Load(program); err != nil {
+			t.Fatal(err)
+		}
+		var values []int
+		for len(values) < 3 {
+			if result := d.Step(); result.Reason != BudgetExhausted {
+				t.Fatalf("stopped: %v", result.Reason)
+			}
+			if d.IP == 28 {
+				values = append(values, d.Registers[4])
+			}
+		}
+		return values
+	}
+	want := haltValues(New(6), program)
+	if got := haltValues(New(6, Width(24), Unsigned()), unmasked); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWidthInvalid(t *testing.T) {
+	for _, options := range [][]Option{
+		{Width(65)},
+		{Width(1)},
+		{Width(64), Unsigned()},
+		{Width(0), Unsigned()},
+		{OnOverflow(Trap + 1)},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("New accepted invalid options")
+				}
+			}()
+			New(4, options...)
+		}()
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// threadID is the only thread a device has.
+const threadID = 1
+
+// registersReference is the variablesReference of the register scope.
+const registersReference = 1
+
+// server speaks the Debug Adapter Protocol for a single device session.
+type server struct {
+	in *bufio.Reader
+
+	outMu sync.Mutex
+	out   io.Writer
+	seq   int
+
+	// mu guards everything below. While the device runs in the background
+	// only pause and disconnect are served.
+	mu          sync.Mutex
+	path        string
+	program     *device.Program
+	device      *device.Device
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+	started     bool
+	running     bool
+	cancel      context.CancelFunc
+	paused      bool
+	done        chan struct{}
+}
+
+func newServer(in io.Reader, out io.Writer) *server {
+	return &server{in: bufio.NewReader(in), out: out}
+}
+
+// serve handles requests until the client disconnects or in is exhausted.
+func (s *server) serve() error {
+	for {
+		request, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if request.Type != "request" {
+			continue
+		}
+		if quit := s.handle(request); quit {
+			return nil
+		}
+	}
+}
+
+func (s *server) send(m *message) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.seq++
+	m.Seq = s.seq
+	if err := writeMessage(s.out, m); err != nil {
+		log.Print(err)
+	}
+}
+
+func (s *server) respond(request *message, body interface{}) {
+	success := true
+	s.send(&message{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: &success, Body: body})
+}
+
+func (s *server) fail(request *message, format string, args ...interface{}) {
+	success := false
+	s.send(&message{Type: "response", RequestSeq: request.Seq, Command: request.Command, Success: &success,
+		Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *server) event(event string, body interface{}) {
+	s.send(&message{Type: "event", Event: event, Body: body})
+}
+
+func (s *server) handle(request *message) bool {
+	switch request.Command {
+	case "pause":
+		s.pause(request)
+		return false
+	case "disconnect", "terminate":
+		s.stop()
+		s.respond(request, nil)
+		if request.Command == "terminate" {
+			s.event("terminated", nil)
+			return false
+		}
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.fail(request, "%s: the device is running", request.Command)
+		return false
+	}
+	switch request.Command {
+	case "initialize":
+		s.respond(request, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsSetVariable":              true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		})
+	case "launch":
+		s.launch(request)
+	case "setBreakpoints":
+		s.setBreakpoints(request)
+	case "setExceptionBreakpoints":
+		s.respond(request, map[string]interface{}{"breakpoints": []breakpoint{}})
+	case "configurationDone":
+		s.configured = true
+		s.respond(request, nil)
+		s.start()
+	case "threads":
+		s.respond(request, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "device"}},
+		})
+	case "stackTrace":
+		s.stackTrace(request)
+	case "scopes":
+		s.respond(request, map[string]interface{}{
+			"scopes": []scope{{Name: "Registers", VariablesReference: registersReference}},
+		})
+	case "variables":
+		s.variables(request)
+	case "setVariable":
+		s.setVariable(request)
+	case "evaluate":
+		s.evaluate(request)
+	case "continue":
+		if !s.checkStarted(request) {
+			return false
+		}
+		s.respond(request, map[string]bool{"allThreadsContinued": true})
+		s.resume(math.MaxInt, "")
+	case "next", "stepIn":
+		if !s.checkStarted(request) {
+			return false
+		}
+		s.respond(request, nil)
+		s.resume(1, "step")
+	default:
+		s.fail(request, "unsupported request %q", request.Command)
+	}
+	return false
+}
+
+func (s *server) checkStarted(request *message) bool {
+	if !s.started {
+		s.fail(request, "%s: the program has not been started", request.Command)
+		return false
+	}
+	return true
+}
+
+func (s *server) launch(request *message) {
+	var args launchArguments
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		s.fail(request, "launch: %v", err)
+		return
+	}
+	if args.NumRegisters < 0 {
+		s.fail(request, "launch: %d registers", args.NumRegisters)
+		return
+	}
+	if args.NumRegisters == 0 {
+		args.NumRegisters = 6
+	}
+	program, err := device.ParseFile(args.Program)
+	if err != nil {
+		s.fail(request, "launch: %v", err)
+		return
+	}
+	d := device.New(args.NumRegisters)
+	if len(args.Registers) > len(d.Registers) {
+		s.fail(request, "launch: %d initial registers for a device with %d", len(args.Registers), len(d.Registers))
+		return
+	}
+	copy(d.Registers, args.Registers)
+	if err := d.Load(program); err != nil {
+		s.fail(request, "launch: %v", err)
+		return
+	}
+	s.path = args.Program
+	s.program = program
+	s.device = d
+	s.stopOnEntry = args.StopOnEntry
+	s.launched = true
+	s.respond(request, nil)
+	// Configuration requests such as setBreakpoints need the program, so
+	// the adapter only declares itself ready once it is loaded.
+	s.event("initialized", nil)
+	s.start()
+}
+
+// start begins execution once the program is launched and configured.
+func (s *server) start() {
+	if !s.launched || !s.configured || s.started {
+		return
+	}
+	s.started = true
+	if s.stopOnEntry {
+		s.event("stopped", stoppedEvent{Reason: "entry", ThreadID: threadID, AllThreadsStopped: true})
+		return
+	}
+	s.resume(math.MaxInt, "")
+}
+
+// resume runs the device for at most n instructions in the background and
+// reports how it stopped. stepReason names the stop when the budget runs
+// out. The caller holds s.mu.
+func (s *server) resume(n int, stepReason string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running = true
+	s.cancel = cancel
+	s.paused = false
+	s.done = make(chan struct{})
+	go func() {
+		result := s.device.RunContext(ctx, n)
+		cancel()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running = false
+		close(s.done)
+		s.report(result, stepReason)
+	}()
+}
+
+// report turns a run's result into events. The caller holds s.mu.
+func (s *server) report(result device.Result, stepReason string) {
+	switch result.Reason {
+	case device.Halted:
+		s.event("output", map[string]string{"category": "console",
+			"output": fmt.Sprintf("halted at ip %d after %d instructions, registers %v\n",
+				result.IP, result.Instructions, result.Registers)})
+		s.event("exited", map[string]int{"exitCode": 0})
+		s.event("terminated", nil)
+	case device.Fault:
+		s.event("output", map[string]string{"category": "stderr", "output": fmt.Sprintf("%v\n", result.Err)})
+		s.event("terminated", nil)
+	case device.BreakpointHit:
+		s.event("stopped", stoppedEvent{Reason: "breakpoint", ThreadID: threadID, AllThreadsStopped: true,
+			HitBreakpointIDs: []int{result.Breakpoint.ID}})
+	case device.WatchpointHit:
+		s.event("stopped", stoppedEvent{Reason: "data breakpoint", ThreadID: threadID, AllThreadsStopped: true,
+			Description: fmt.Sprintf("r%d written", result.Watchpoint.Register)})
+	case device.Cancelled:
+		if s.paused {
+			s.event("stopped", stoppedEvent{Reason: "pause", ThreadID: threadID, AllThreadsStopped: true})
+		}
+	case device.BudgetExhausted:
+		s.event("stopped", stoppedEvent{Reason: stepReason, ThreadID: threadID, AllThreadsStopped: true})
+	}
+}
+
+func (s *server) pause(request *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.paused = true
+		s.cancel()
+	}
+	// Respond while holding s.mu so that the stopped event follows.
+	s.respond(request, nil)
+}
+
+// stop cancels a background run and waits for it to finish.
+func (s *server) stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	done := s.done
+	s.mu.Unlock()
+	<-done
+}
+
+func (s *server) samePath(path string) bool {
+	a, errA := filepath.Abs(path)
+	b, errB := filepath.Abs(s.path)
+	return errA == nil && errB == nil && a == b
+}
+
+func (s *server) setBreakpoints(request *message) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		s.fail(request, "setBreakpoints: %v", err)
+		return
+	}
+	if s.device == nil {
+		s.fail(request, "setBreakpoints: no program launched")
+		return
+	}
+	src := source{Name: filepath.Base(s.path), Path: args.Source.Path}
+	breakpoints := []breakpoint{}
+	if !s.samePath(args.Source.Path) {
+		for _, requested := range args.Breakpoints {
+			breakpoints = append(breakpoints, breakpoint{Line: requested.Line, Source: src,
+				Message: fmt.Sprintf("not the launched program %s", s.path)})
+		}
+		s.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+		return
+	}
+	// The request replaces every breakpoint in the source.
+	for _, existing := range s.device.Breakpoints() {
+		s.device.ClearBreakpoint(existing.ID)
+	}
+	for _, requested := range args.Breakpoints {
+		ip, ok := s.program.InstructionAt(requested.Line)
+		if !ok {
+			breakpoints = append(breakpoints, breakpoint{Line: requested.Line, Source: src,
+				Message: "no instruction on or after this line"})
+			continue
+		}
+		set, err := s.device.SetBreakpoint(ip, requested.Condition)
+		if err != nil {
+			breakpoints = append(breakpoints, breakpoint{Line: requested.Line, Source: src, Message: err.Error()})
+			continue
+		}
+		breakpoints = append(breakpoints, breakpoint{ID: set.ID, Verified: true, Line: s.program.Lines[ip], Source: src})
+	}
+	s.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+}
+
+func (s *server) stackTrace(request *message) {
+	frames := []stackFrame{}
+	if s.device != nil && s.device.IP >= 0 && s.device.IP < len(s.program.Instructions) {
+		ip := s.device.IP
+		frames = append(frames, stackFrame{
+			ID:     1,
+			Name:   fmt.Sprintf("%d: %v", ip, s.program.Instructions[ip]),
+			Source: source{Name: filepath.Base(s.path), Path: s.path},
+			Line:   s.program.Lines[ip],
+			Column: 1,
+		})
+	}
+	s.respond(request, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+func (s *server) variables(request *message) {
+	variables := []variable{}
+	if s.device != nil {
+		variables = append(variables, variable{Name: "ip", Value: strconv.Itoa(s.device.IP), Type: "int"})
+		for i, value := range s.device.Registers {
+			variables = append(variables, variable{Name: fmt.Sprintf("r%d", i), Value: strconv.Itoa(value), Type: "int"})
+		}
+		variables = append(variables, variable{Name: "executed", Value: strconv.Itoa(s.device.Executed), Type: "int"})
+	}
+	s.respond(request, map[string]interface{}{"variables": variables})
+}
+
+// lookup returns a pointer to the named register or the instruction
+// pointer.
+func (s *server) lookup(name string) (*int, error) {
+	if s.device == nil {
+		return nil, errors.New("no program launched")
+	}
+	name = strings.TrimSpace(name)
+	if name == "ip" {
+		return &s.device.IP, nil
+	}
+	if strings.HasPrefix(name, "r") {
+		if register, err := strconv.Atoi(name[1:]); err == nil && register >= 0 && register < len(s.device.Registers) {
+			return &s.device.Registers[register], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown variable %q", name)
+}
+
+func (s *server) setVariable(request *message) {
+	var args setVariableArguments
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		s.fail(request, "setVariable: %v", err)
+		return
+	}
+	target, err := s.lookup(args.Name)
+	if err != nil {
+		s.fail(request, "setVariable: %v", err)
+		return
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(args.Value))
+	if err != nil {
+		s.fail(request, "setVariable: %q is not a number", args.Value)
+		return
+	}
+	*target = value
+	s.respond(request, variable{Value: strconv.Itoa(value), Type: "int"})
+}
+
+func (s *server) evaluate(request *message) {
+	var args evaluateArguments
+	if err := json.Unmarshal(request.Arguments, &args); err != nil {
+		s.fail(request, "evaluate: %v", err)
+		return
+	}
+	target, err := s.lookup(args.Expression)
+	if err != nil {
+		s.fail(request, "evaluate: %v", err)
+		return
+	}
+	s.respond(request, map[string]interface{}{"result": strconv.Itoa(*target), "variablesReference": 0})
+}
+
+func main() {
+	// stdout carries the protocol, so diagnostics go to stderr.
+	log.SetOutput(os.Stderr)
+	if err := newServer(os.Stdin, os.Stdout).serve(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// client is a scripted DAP client talking to a server over pipes.
+type client struct {
+	t   *testing.T
+	in  *bufio.Reader
+	out io.Writer
+	seq int
+}
+
+func startServer(t *testing.T) *client {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		newServer(serverIn, serverOut).serve()
+		serverOut.Close()
+	}()
+	return &client{t: t, in: bufio.NewReader(clientIn), out: clientOut}
+}
+
+func (c *client) send(command string, arguments interface{}) int {
+	c.seq++
+	raw, err := json.Marshal(arguments)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := writeMessage(c.out, &message{Seq: c.seq, Type: "request", Command: command, Arguments: raw}); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.seq
+}
+
+// next reads the next message, failing the test if none arrives quickly.
+func (c *client) next() *message {
+	received := make(chan *message)
+	go func() {
+		m, err := readMessage(c.in)
+		if err != nil {
+			c.t.Error(err)
+		}
+		received <- m
+	}()
+	select {
+	case m := <-received:
+		if m == nil {
+			c.t.FailNow()
+		}
+		return m
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out waiting for a message")
+	}
+	return nil
+}
+
+// request sends a request and returns the body of its successful response.
+func (c *client) request(command string, arguments interface{}) map[string]interface{} {
+	seq := c.send(command, arguments)
+	m := c.next()
+	if m.Type != "response" || m.RequestSeq != seq {
+		c.t.Fatalf("Expected response to %s, got %+v", command, m)
+	}
+	if m.Success == nil || !*m.Success {
+		c.t.Fatalf("%s failed: %s", command, m.Message)
+	}
+	body, _ := m.Body.(map[string]interface{})
+	return body
+}
+
+func (c *client) expectEvent(event string) map[string]interface{} {
+	m := c.next()
+	if m.Type != "event" || m.Event != event {
+		c.t.Fatalf("Expected %s event, got %+v", event, m)
+	}
+	body, _ := m.Body.(map[string]interface{})
+	return body
+}
+
+func (c *client) registers() map[string]string {
+	body := c.request("variables", map[string]int{"variablesReference": registersReference})
+	values := make(map[string]string)
+	for _, v := range body["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		values[variable["name"].(string)] = variable["value"].(string)
+	}
+	return values
+}
+
+func TestSession(t *testing.T) {
+	c := startServer(t)
+	capabilities := c.request("initialize", map[string]string{"adapterID": "elfcode"})
+	if capabilities["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("initialize capabilities = %v", capabilities)
+	}
+	c.request("launch", launchArguments{Program: "../../day21/input.txt"})
+	c.expectEvent("initialized")
+
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      source{Path: "../../day21/input.txt"},
+		"breakpoints": []map[string]interface{}{{"line": 30}, {"line": 99}},
+	})
+	breakpoints := body["breakpoints"].([]interface{})
+	if first := breakpoints[0].(map[string]interface{}); first["verified"] != true || first["line"] != 30.0 {
+		t.Errorf("Breakpoint on line 30 = %v", first)
+	}
+	if second := breakpoints[1].(map[string]interface{}); second["verified"] != false {
+		t.Errorf("Breakpoint on line 99 = %v", second)
+	}
+
+	c.request("configurationDone", nil)
+	stopped := c.expectEvent("stopped")
+	if stopped["reason"] != "breakpoint" {
+		t.Errorf("Stopped event = %v", stopped)
+	}
+
+	frames := c.request("stackTrace", map[string]int{"threadId": threadID})["stackFrames"].([]interface{})
+	if frame := frames[0].(map[string]interface{}); frame["line"] != 30.0 || frame["name"] != "28: eqrr 4 0 5" {
+		t.Errorf("Top frame = %v", frame)
+	}
+	scopes := c.request("scopes", map[string]int{"frameId": 1})["scopes"].([]interface{})
+	if len(scopes) != 1 {
+		t.Errorf("Scopes = %v", scopes)
+	}
+	registers := c.registers()
+	if registers["r4"] != "15823996" || registers["ip"] != "28" {
+		t.Errorf("Registers at the breakpoint = %v", registers)
+	}
+
+	c.request("next", map[string]int{"threadId": threadID})
+	if stopped := c.expectEvent("stopped"); stopped["reason"] != "step" {
+		t.Errorf("Stopped event after next = %v", stopped)
+	}
+	if registers := c.registers(); registers["ip"] != "29" || registers["r5"] != "0" {
+		t.Errorf("Registers after next = %v", registers)
+	}
+
+	// Make r0 match on the next pass through the comparison and run to the end.
+	c.request("setBreakpoints", map[string]interface{}{"source": source{Path: "../../day21/input.txt"}})
+	c.request("setVariable", setVariableArguments{VariablesReference: registersReference, Name: "ip", Value: "28"})
+	c.request("setVariable", setVariableArguments{VariablesReference: registersReference, Name: "r0", Value: "15823996"})
+	if result := c.request("evaluate", evaluateArguments{Expression: "r0"}); result["result"] != "15823996" {
+		t.Errorf("evaluate r0 = %v", result)
+	}
+	c.request("continue", map[string]int{"threadId": threadID})
+	c.expectEvent("output")
+	c.expectEvent("exited")
+	c.expectEvent("terminated")
+	c.request("disconnect", nil)
+}
+
+func TestPause(t *testing.T) {
+	c := startServer(t)
+	c.request("initialize", nil)
+	c.request("launch", launchArguments{Program: "testdata/forever.txt", StopOnEntry: true})
+	c.expectEvent("initialized")
+	c.request("configurationDone", nil)
+	if stopped := c.expectEvent("stopped"); stopped["reason"] != "entry" {
+		t.Errorf("Stopped event = %v", stopped)
+	}
+	c.request("continue", map[string]int{"threadId": threadID})
+	time.Sleep(10 * time.Millisecond)
+	c.request("pause", map[string]int{"threadId": threadID})
+	if stopped := c.expectEvent("stopped"); stopped["reason"] != "pause" {
+		t.Errorf("Stopped event = %v", stopped)
+	}
+	c.request("disconnect", nil)
+}
+
+func TestLaunchInvalid(t *testing.T) {
+	c := startServer(t)
+	c.request("initialize", map[string]string{"adapterID": "elfcode"})
+	seq := c.send("launch", launchArguments{Program: "../../day21/input.txt", NumRegisters: -1})
+	m := c.next()
+	if m.Type != "response" || m.RequestSeq != seq || m.Success == nil || *m.Success {
+		t.Errorf("launch with -1 registers gave %+v", m)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+const help = `Commands:
+  load <file>            load a program and reset the device
+  reset                  reload the current program with zeroed registers
+  step [n], s [n]        execute n instructions (default 1)
+  continue, c            run until the program halts or stops
+  break <ip> [if <cond>] set a breakpoint, e.g. break 28 if r4 changed
+  watch r<n>             stop after an instruction writes r<n>
+  clear <id>             remove a breakpoint or watchpoint
+  info                   list breakpoints and watchpoints
+  regs, print [r<n>|ip]  show registers
+  set r<n>|ip <value>    change a register or the ip; setting the #ip register jumps
+  disas [n]              disassemble n instructions either side of the ip
+  list [n]               show n source lines either side of the ip
+  help                   show this message
+  quit, q                exit`
+
+// debugger is the state of an interactive session against one program.
+type debugger struct {
+	out          io.Writer
+	numRegisters int
+	filename     string
+	source       []string
+	program      *device.Program
+	device       *device.Device
+	// runContext supplies the context for continue, so that it can be
+	// interrupted.
+	runContext func() (context.Context, context.CancelFunc)
+}
+
+func newDebugger(out io.Writer, numRegisters int) *debugger {
+	return &debugger{
+		out:          out,
+		numRegisters: numRegisters,
+		runContext: func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		},
+	}
+}
+
+func (dbg *debugger) printf(format string, args ...interface{}) {
+	fmt.Fprintf(dbg.out, format, args...)
+}
+
+func (dbg *debugger) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		dbg.printf("(elfdbg) ")
+		if !scanner.Scan() {
+			dbg.printf("\n")
+			return
+		}
+		if quit := dbg.execute(scanner.Text()); quit {
+			return
+		}
+	}
+}
+
+// execute runs one command line, reporting whether the session should end.
+func (dbg *debugger) execute(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	command, args := fields[0], fields[1:]
+	switch command {
+	case "quit", "q":
+		return true
+	case "help", "h":
+		dbg.printf("%s\n", help)
+	case "load":
+		if len(args) != 1 {
+			dbg.printf("usage: load <file>\n")
+			return false
+		}
+		dbg.load(args[0])
+	default:
+		if dbg.device == nil {
+			dbg.printf("no program loaded\n")
+			return false
+		}
+		dbg.executeLoaded(command, args)
+	}
+	return false
+}
+
+func (dbg *debugger) executeLoaded(command string, args []string) {
+	switch command {
+	case "reset":
+		dbg.load(dbg.filename)
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				dbg.printf("bad step count %q\n", args[0])
+				return
+			}
+		}
+		dbg.report(dbg.device.Run(n))
+	case "continue", "c":
+		ctx, cancel := dbg.runContext()
+		result := dbg.device.RunContext(ctx, math.MaxInt)
+		cancel()
+		dbg.report(result)
+	case "break", "b":
+		dbg.setBreakpoint(args)
+	case "watch":
+		register, ok := dbg.register(args)
+		if !ok {
+			return
+		}
+		watchpoint, err := dbg.device.Watch(register)
+		if err != nil {
+			dbg.printf("%v\n", err)
+			return
+		}
+		dbg.printf("watchpoint %d on r%d\n", watchpoint.ID, register)
+	case "clear":
+		if len(args) != 1 {
+			dbg.printf("usage: clear <id>\n")
+			return
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil || !(dbg.device.ClearBreakpoint(id) || dbg.device.ClearWatchpoint(id)) {
+			dbg.printf("no breakpoint or watchpoint %s\n", args[0])
+			return
+		}
+		dbg.printf("cleared %d\n", id)
+	case "info":
+		for _, breakpoint := range dbg.device.Breakpoints() {
+			dbg.printf("breakpoint %d at ip %d", breakpoint.ID, breakpoint.IP)
+			if breakpoint.Condition != "" {
+				dbg.printf(" if %s", breakpoint.Condition)
+			}
+			dbg.printf(", hit %d times\n", breakpoint.Hits)
+		}
+		for _, watchpoint := range dbg.device.Watchpoints() {
+			dbg.printf("watchpoint %d on r%d, hit %d times\n", watchpoint.ID, watchpoint.Register, watchpoint.Hits)
+		}
+	case "regs", "print", "p":
+		dbg.print(args)
+	case "set":
+		dbg.set(args)
+	case "disas":
+		dbg.disassemble(dbg.context(args, 3))
+	case "list", "l":
+		dbg.list(dbg.context(args, 3))
+	default:
+		dbg.printf("unknown command %q, try help\n", command)
+	}
+}
+
+func (dbg *debugger) load(filename string) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		dbg.printf("%v\n", err)
+		return
+	}
+	program, err := device.ParseReader(bytes.NewReader(contents))
+	if err != nil {
+		dbg.printf("%s: %v\n", filename, err)
+		return
+	}
+	newDevice := device.New(dbg.numRegisters)
+	if err := newDevice.Load(program); err != nil {
+		dbg.printf("%s: %v\n", filename, err)
+		return
+	}
+	if dbg.device != nil && dbg.filename == filename {
+		// Keep breakpoints and watchpoints across a reset.
+		for _, breakpoint := range dbg.device.Breakpoints() {
+			newDevice.SetBreakpoint(breakpoint.IP, breakpoint.Condition)
+		}
+		for _, watchpoint := range dbg.device.Watchpoints() {
+			newDevice.Watch(watchpoint.Register)
+		}
+	}
+	dbg.filename = filename
+	dbg.source = strings.Split(string(contents), "\n")
+	dbg.program = program
+	dbg.device = newDevice
+	dbg.printf("loaded %s: %d instructions, #ip %d\n", filename, len(program.Instructions), program.IP)
+}
+
+func (dbg *debugger) report(result device.Result) {
+	switch result.Reason {
+	case device.BreakpointHit:
+		dbg.printf("breakpoint %d, ", result.Breakpoint.ID)
+	case device.WatchpointHit:
+		dbg.printf("watchpoint %d (r%d = %d), ", result.Watchpoint.ID, result.Watchpoint.Register, result.Registers[result.Watchpoint.Register])
+	case device.BudgetExhausted:
+	default:
+		dbg.printf("%v, ", result.Reason)
+		if result.Err != nil {
+			dbg.printf("%v, ", result.Err)
+		}
+	}
+	dbg.printf("ip %d after %d instructions\n", result.IP, result.Instructions)
+	if result.Reason != device.Halted {
+		dbg.disassemble(0)
+	}
+}
+
+func (dbg *debugger) setBreakpoint(args []string) {
+	if len(args) == 0 {
+		dbg.printf("usage: break <ip> [if <condition>]\n")
+		return
+	}
+	ip, err := strconv.Atoi(args[0])
+	if err != nil || ip < 0 || ip >= len(dbg.program.Instructions) {
+		dbg.printf("bad instruction %q\n", args[0])
+		return
+	}
+	condition := ""
+	if len(args) > 1 {
+		if args[1] != "if" || len(args) == 2 {
+			dbg.printf("usage: break <ip> [if <condition>]\n")
+			return
+		}
+		condition = strings.Join(args[2:], " ")
+	}
+	breakpoint, err := dbg.device.SetBreakpoint(ip, condition)
+	if err != nil {
+		dbg.printf("%v\n", err)
+		return
+	}
+	dbg.printf("breakpoint %d at ip %d\n", breakpoint.ID, ip)
+}
+
+func (dbg *debugger) register(args []string) (int, bool) {
+	if len(args) != 1 || !strings.HasPrefix(args[0], "r") {
+		dbg.printf("expected a register such as r0\n")
+		return 0, false
+	}
+	register, err := strconv.Atoi(args[0][1:])
+	if err != nil || register < 0 || register >= len(dbg.device.Registers) {
+		dbg.printf("no register %s\n", args[0])
+		return 0, false
+	}
+	return register, true
+}
+
+func (dbg *debugger) print(args []string) {
+	if len(args) == 0 {
+		dbg.printf("ip=%d", dbg.device.IP)
+		for i, value := range dbg.device.Registers {
+			dbg.printf(" r%d=%d", i, value)
+		}
+		dbg.printf("\n")
+		return
+	}
+	if args[0] == "ip" {
+		dbg.printf("ip=%d\n", dbg.device.IP)
+		return
+	}
+	if register, ok := dbg.register(args); ok {
+		dbg.printf("r%d=%d\n", register, dbg.device.Registers[register])
+	}
+}
+
+func (dbg *debugger) set(args []string) {
+	if len(args) != 2 {
+		dbg.printf("usage: set r<n>|ip <value>\n")
+		return
+	}
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		dbg.printf("bad value %q\n", args[1])
+		return
+	}
+	if args[0] == "ip" {
+		dbg.device.IP = value
+	} else if register, ok := dbg.register(args[:1]); ok {
+		dbg.device.Registers[register] = value
+		if register == dbg.program.IP {
+			// The device copies the instruction pointer into the IP
+			// register before each instruction, so writing it is a jump.
+			dbg.device.IP = value
+			dbg.print(nil)
+			return
+		}
+	} else {
+		return
+	}
+	dbg.print(args[:1])
+}
+
+func (dbg *debugger) context(args []string, fallback int) int {
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return fallback
+}
+
+func (dbg *debugger) disassemble(around int) {
+	ip := dbg.device.IP
+	breakpoints := make(map[int]bool)
+	for _, breakpoint := range dbg.device.Breakpoints() {
+		breakpoints[breakpoint.IP] = true
+	}
+	for i := ip - around; i <= ip+around; i++ {
+		if i < 0 || i >= len(dbg.program.Instructions) {
+			continue
+		}
+		marker := "   "
+		if i == ip {
+			marker = "=> "
+		}
+		if breakpoints[i] {
+			marker = marker[:2] + "*"
+		}
+		dbg.printf("%s %3d  %-16v line %d\n", marker, i, dbg.program.Instructions[i], dbg.program.Lines[i])
+	}
+}
+
+func (dbg *debugger) list(around int) {
+	ip := dbg.device.IP
+	if ip < 0 || ip >= len(dbg.program.Instructions) {
+		dbg.printf("ip %d is outside the program\n", ip)
+		return
+	}
+	line := dbg.program.Lines[ip]
+	for l := line - around; l <= line+around; l++ {
+		if l < 1 || l > len(dbg.source) {
+			continue
+		}
+		marker := "  "
+		if l == line {
+			marker = "=>"
+		}
+		dbg.printf("%s %3d  %s\n", marker, l, dbg.source[l-1])
+	}
+}
+
+func main() {
+	numRegisters := flag.Int("registers", 6, "number of device registers")
+	flag.Parse()
+	if *numRegisters < 1 {
+		fmt.Fprintf(os.Stderr, "elfdbg: -registers must be at least 1, not %d\n", *numRegisters)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dbg := newDebugger(os.Stdout, *numRegisters)
+	dbg.runContext = func() (context.Context, context.CancelFunc) {
+		return signal.NotifyContext(context.Background(), os.Interrupt)
+	}
+	if flag.NArg() > 0 {
+		dbg.load(flag.Arg(0))
+	}
+	dbg.run(os.Stdin)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func session(t *testing.T, commands ...string) string {
+	var out strings.Builder
+	dbg := newDebugger(&out, 6)
+	dbg.run(strings.NewReader(strings.Join(commands, "\n")))
+	return out.String()
+}
+
+func TestSession(t *testing.T) {
+	out := session(t,
+		"load ../../day21/input.txt",
+		"break 28",
+		"continue",
+		"print r4",
+		"list 0",
+		"step 2",
+		"set r0 7",
+		"regs",
+		"info",
+	)
+	for _, expected := range []string{
+		"loaded ../../day21/input.txt: 31 instructions, #ip 1",
+		"breakpoint 1 at ip 28\n",
+		"breakpoint 1, ip 28 after 1846 instructions\n",
+		"=>*  28  eqrr 4 0 5       line 30\n",
+		"r4=15823996\n",
+		"=>  30  eqrr 4 0 5\n",
+		"ip 30 after 1848 instructions\n",
+		"r0=7\n",
+		"ip=30 r0=7 r1=29 r2=1 r3=1 r4=15823996 r5=0\n",
+		"breakpoint 1 at ip 28, hit 1 times\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Session output does not contain %q:\n%s", expected, out)
+		}
+	}
+}
+
+func TestSetIPRegister(t *testing.T) {
+	out := session(t,
+		"load ../../day21/input.txt",
+		"set r1 5",
+		"step",
+	)
+	for _, expected := range []string{
+		"ip=5 r0=0 r1=5 r2=0 r3=0 r4=0 r5=0\n",
+		"ip 6 after 1 instructions\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Session output does not contain %q:\n%s", expected, out)
+		}
+	}
+}
+
+func TestConditionalBreakAndWatch(t *testing.T) {
+	out := session(t,
+		"load ../input.txt",
+		"watch r0",
+		"break 3 if r2 == 3",
+		"c",
+		"clear 2",
+		"c",
+		"clear 1",
+		"reset",
+		"info",
+	)
+	for _, expected := range []string{
+		"watchpoint 1 on r0\n",
+		"breakpoint 2 at ip 3\n",
+		"breakpoint 2, ip 3 after 29 instructions\n",
+		"watchpoint 1 (r0 = 1), ip 8 after",
+		"cleared 1\n",
+		"cleared 2\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Session output does not contain %q:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "hit 0 times") {
+		t.Errorf("Breakpoints survived being cleared:\n%s", out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	out := session(t,
+		"step",
+		"load does-not-exist.txt",
+		"load ../input.txt",
+		"break 99",
+		"break 3 if r9 == 1",
+		"watch r6",
+		"frobnicate",
+	)
+	for _, expected := range []string{
+		"no program loaded\n",
+		"does-not-exist.txt",
+		"bad instruction \"99\"\n",
+		"no register \"r9\"",
+		"no register r6\n",
+		"unknown command \"frobnicate\"",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Session output does not contain %q:\n%s", expected, out)
+		}
+	}
+}
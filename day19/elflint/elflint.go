@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"io"
+	"os"
+)
+
+// fileFinding is a finding in one of the files linted.
+type fileFinding struct {
+	File string `json:"file"`
+	device.Finding
+}
+
+// lint reports the findings for each program in filenames to w, as text or
+// as a JSON array, and returns how many there were.
+func lint(w io.Writer, filenames []string, numRegisters int, asJSON bool) (int, error) {
+	findings := []fileFinding{}
+	for _, filename := range filenames {
+		program, err := device.ParseFile(filename)
+		if err != nil {
+			return 0, err
+		}
+		if err := program.Validate(numRegisters); err != nil {
+			return 0, fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, finding := range program.Lint() {
+			findings = append(findings, fileFinding{filename, finding})
+		}
+	}
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return len(findings), encoder.Encode(findings)
+	}
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "%s:%d: %s (%s)\n", f.File, f.Line, f.Message, f.Check); err != nil {
+			return 0, err
+		}
+	}
+	return len(findings), nil
+}
+
+func main() {
+	numRegisters := flag.Int("registers", 6, "number of device registers")
+	asJSON := flag.Bool("json", false, "print findings as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: elflint [-registers n] [-json] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	n, err := lint(os.Stdout, flag.Args(), *numRegisters, *asJSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "elflint:", err)
+		os.Exit(2)
+	}
+	if n > 0 {
+		os.Exit(1)
+	}
+}
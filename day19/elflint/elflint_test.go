@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintText(t *testing.T) {
+	var out strings.Builder
+	n, err := lint(&out, []string{"../input.txt", "../../day21/input.txt"}, 6, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `../input.txt:18: mulr 3 3 3 jumps to 257, outside the program (jump-outside)
+../../day21/input.txt:6: seti 0 0 1 is never reached (unreachable)
+../../day21/input.txt:30: eqrr 4 0 5 reads r0, which is never written (never-written)
+`
+	if n != 3 || out.String() != expected {
+		t.Errorf("got %d findings:\n%s\nexpected 3:\n%s", n, out.String(), expected)
+	}
+}
+
+func TestLintJSON(t *testing.T) {
+	var out strings.Builder
+	if _, err := lint(&out, []string{"../../day21/input.txt"}, 6, true); err != nil {
+		t.Fatal(err)
+	}
+	var findings []fileFinding
+	if err := json.Unmarshal([]byte(out.String()), &findings); err != nil {
+		t.Fatalf("%v in %q", err, out.String())
+	}
+	expected := []fileFinding{
+		{"../../day21/input.txt", device.Finding{Index: 4, Line: 6, Check: device.CheckUnreachable, Message: "seti 0 0 1 is never reached"}},
+		{"../../day21/input.txt", device.Finding{Index: 28, Line: 30, Check: device.CheckNeverWritten, Message: "eqrr 4 0 5 reads r0, which is never written"}},
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("got %+v", findings)
+	}
+	if !strings.Contains(out.String(), `"line": 30`) {
+		t.Errorf("findings not flattened: %s", out.String())
+	}
+}
+
+func TestLintInvalid(t *testing.T) {
+	var out strings.Builder
+	if _, err := lint(&out, []string{"../input.txt"}, 4, false); err == nil {
+		t.Error("lint accepted a program using more registers than configured")
+	}
+	if _, err := lint(&out, []string{"missing.txt"}, 6, false); err == nil {
+		t.Error("lint accepted a missing file")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"io"
+	"os"
+	"os/signal"
+)
+
+const usage = `usage: elfrun run [flags] program
+       elfrun resume [flags] program
+
+run starts the program from zeroed registers, save for -r0. resume carries
+on from the checkpoint of an earlier run of the same program.
+
+Flags:
+`
+
+// options are the flags of both commands.
+type options struct {
+	checkpoint   string
+	every        int
+	numRegisters int
+	interpret    bool
+	r0           int
+}
+
+// execute runs the command line args, writing progress to w.
+func execute(ctx context.Context, w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("elfrun", flag.ContinueOnError)
+	flags.SetOutput(w)
+	flags.Usage = func() {
+		fmt.Fprint(w, usage)
+		flags.PrintDefaults()
+	}
+	var o options
+	flags.StringVar(&o.checkpoint, "checkpoint", "elfrun.checkpoint", "checkpoint file")
+	flags.IntVar(&o.every, "every", 100000000, "instructions between checkpoints")
+	flags.IntVar(&o.numRegisters, "registers", 6, "number of device registers")
+	flags.BoolVar(&o.interpret, "interpret", false, "look up each operation as it runs, without compiling")
+	flags.IntVar(&o.r0, "r0", 0, "initial value of r0 (run only)")
+	if len(args) == 0 || args[0] != "run" && args[0] != "resume" {
+		flags.Usage()
+		return errors.New("expected run or resume")
+	}
+	if err := flags.Parse(args[1:]); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || o.every < 1 || o.numRegisters < 1 {
+		flags.Usage()
+		return errors.New("expected one program, a positive -every and a positive -registers")
+	}
+
+	program, err := device.ParseFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	d := device.New(o.numRegisters)
+	d.Interpret = o.interpret
+	if err := d.Load(program); err != nil {
+		return err
+	}
+	if args[0] == "run" {
+		d.Registers[0] = o.r0
+	} else {
+		c, err := device.ReadCheckpoint(o.checkpoint)
+		if err != nil {
+			return err
+		}
+		if err := d.Restore(c); err != nil {
+			return fmt.Errorf("%s: %w", o.checkpoint, err)
+		}
+		fmt.Fprintf(w, "resuming at ip %d after %d instructions\n", d.IP, d.Executed)
+	}
+	return run(ctx, w, d, o)
+}
+
+// run runs the device until it stops for good, writing a checkpoint every
+// o.every instructions and when it stops.
+func run(ctx context.Context, w io.Writer, d *device.Device, o options) error {
+	for {
+		result := d.RunContext(ctx, o.every)
+		c, err := d.Checkpoint()
+		if err != nil {
+			return err
+		}
+		if err := device.WriteCheckpoint(o.checkpoint, c); err != nil {
+			return err
+		}
+		switch result.Reason {
+		case device.BudgetExhausted:
+			continue
+		case device.Cancelled:
+			fmt.Fprintf(w, "interrupted at ip %d after %d instructions, checkpoint written to %s\n", result.IP, result.Instructions, o.checkpoint)
+			return nil
+		case device.Fault:
+			return result.Err
+		}
+		fmt.Fprintf(w, "%v at ip %d after %d instructions\nregisters %v\n", result.Reason, result.IP, result.Instructions, result.Registers)
+		return nil
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := execute(ctx, os.Stdout, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "elfrun:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const day21 = "../../day21/input.txt"
+
+// halting is the day 21 input for which the program halts soonest.
+const halting = 15823996
+
+// finalState returns how a run of day 21 from r0 = halting ends, as
+// reported by elfrun.
+func finalState(t *testing.T) string {
+	program, err := device.ParseFile(day21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := device.New(6)
+	d.Registers[0] = halting
+	result, err := d.Execute(context.Background(), program, 1000000)
+	if err != nil || result.Reason != device.Halted {
+		t.Fatalf("Execute = %+v, %v", result, err)
+	}
+	return fmt.Sprintf("halted at ip %d after %d instructions\nregisters %v\n", result.IP, result.Instructions, result.Registers)
+}
+
+func TestRun(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "run.checkpoint")
+	var out strings.Builder
+	args := []string{"run", "-checkpoint", checkpoint, "-every", "100", "-interpret", "-r0", fmt.Sprint(halting), day21}
+	if err := execute(context.Background(), &out, args); err != nil {
+		t.Fatal(err)
+	}
+	want := finalState(t)
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	c, err := device.ReadCheckpoint(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(want, fmt.Sprintf("after %d instructions", c.Instructions)) {
+		t.Errorf("final checkpoint %+v", c)
+	}
+}
+
+func TestResume(t *testing.T) {
+	program, err := device.ParseFile(day21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := device.New(6)
+	d.Registers[0] = halting
+	if err := d.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	d.Run(1000)
+	c, err := d.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := filepath.Join(t.TempDir(), "run.checkpoint")
+	if err := device.WriteCheckpoint(checkpoint, c); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := execute(context.Background(), &out, []string{"resume", "-checkpoint", checkpoint, day21}); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("resuming at ip %d after 1000 instructions\n", c.IP) + finalState(t)
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	err = execute(context.Background(), &out, []string{"resume", "-checkpoint", checkpoint, "../input.txt"})
+	if !errors.Is(err, device.ErrProgramMismatch) {
+		t.Errorf("resuming against another program = %v", err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "run.checkpoint")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out strings.Builder
+	if err := execute(ctx, &out, []string{"run", "-checkpoint", checkpoint, "-r0", fmt.Sprint(halting), day21}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "interrupted at ip 0 after 0 instructions") {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	if err := execute(context.Background(), &out, []string{"resume", "-checkpoint", checkpoint, "-every", "500", day21}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "resuming at ip 0 after 0 instructions\n" + finalState(t); out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"run"},
+		{"walk", day21},
+		{"run", "-every", "0", day21},
+		{"run", "-registers", "-1", day21},
+		{"resume", "-registers", "0", day21},
+	} {
+		var out strings.Builder
+		if err := execute(context.Background(), &out, args); err == nil {
+			t.Errorf("execute accepted %q", args)
+		}
+	}
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/enjean/advent-of-code-2018-go/day19/device"
+	"log"
+	"math"
 )
 
 func main() {
 	program := device.Parse("day21/input.txt")
 
-	fmt.Println(program.ToGo())
+	source, err := program.ToGo("elfcode", 6)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(source)
 
-	testDevice := device.New(6)
-	testDevice.Registers[0] = 15823996
-	executions := testDevice.Execute(program, 100000)
-	fmt.Println(executions)
+	analysis, err := device.AnalyzeHaltingInputs(context.Background(), program, make([]int, 6), math.MaxInt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fewest, ok := analysis.Fewest()
+	if !ok {
+		log.Fatalf("No value of r0 halts the program (%v)", analysis.Stop)
+	}
+	most, _ := analysis.Most()
+	fmt.Printf("Part 1 = %d\n", fewest.R0)
+	fmt.Printf("Part 2 = %d\n", most.R0)
 }